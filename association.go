@@ -0,0 +1,405 @@
+package opal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Associator is implemented by Models with associations so the
+// related Models Opal loads can be cached on them. Associated
+// gets the cached Models or nil when they have not been loaded.
+type Associator interface {
+	Associate(pAssociation string, pModels []Model)
+	Associated(pAssociation string) []Model
+}
+
+// ******************************************** Find options
+
+// FindOption changes how Models are found
+type FindOption func(*findOptions)
+
+type findOptions struct {
+	preload []string
+	deleted deletedScope
+}
+
+func newFindOptions(pOptions []FindOption) *findOptions {
+	o := new(findOptions)
+	for _, option := range pOptions {
+		option(o)
+	}
+	return o
+}
+
+// Preload loads the named associations of the found Models with
+// one query per association rather than one for every Model.
+// Nested associations are named by their path e.g. Pets.Toys.
+// A ManyToMany reads its join table first so takes two queries.
+func Preload(pAssociations ...string) FindOption {
+	return func(o *findOptions) {
+		o.preload = append(o.preload, pAssociations...)
+	}
+}
+
+// WithDeleted includes the soft deleted Models in a find
+func WithDeleted() FindOption {
+	return func(o *findOptions) {
+		o.deleted = withDeleted
+	}
+}
+
+// OnlyDeleted finds the soft deleted Models only
+func OnlyDeleted() FindOption {
+	return func(o *findOptions) {
+		o.deleted = onlyDeleted
+	}
+}
+
+// ******************************************** Eager loading
+
+// Loads the associations along each path onto the Models
+// failing on the first association which cannot be loaded
+func (o *Gem) preload(pCtx context.Context, pModelName ModelName, pModels []Model, pPaths []string) error {
+	if len(pModels) == 0 || len(pPaths) == 0 {
+		return nil
+	}
+	// Group the nested paths by their first association
+	var names []string
+	nested := make(map[string][]string)
+	for _, path := range pPaths {
+		parts := strings.SplitN(path, ".", 2)
+		if _, ok := nested[parts[0]]; !ok {
+			names = append(names, parts[0])
+			nested[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+	meta := o.allModelsMetadata[pModelName]
+	for _, name := range names {
+		association, ok := meta.Association(name)
+		if !ok {
+			panic(fmt.Sprintf("Opal.Preload: %s has no association %s", pModelName, name))
+		}
+		found, err := o.loadAssociation(pCtx, meta, association, pModels)
+		if err != nil {
+			return err
+		}
+		if err := o.preload(pCtx, association.Model, found, nested[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the related Models of an association for all the Models
+// at once, hands each Model its own and returns them all
+func (o *Gem) loadAssociation(pCtx context.Context, pMeta ModelMetadata, pAssociation Association, pModels []Model) ([]Model, error) {
+	related := o.allModelsMetadata[pAssociation.Model]
+	dao := &ModelIDAO{gem: o, model: pAssociation.Model, ctx: pCtx}
+	relatedKey := related.Keys()[0].Identifier
+	key := pMeta.Keys()[0].Identifier
+
+	var found []Model
+	var err error
+	children := make(map[interface{}][]Model)
+	switch pAssociation.Kind {
+	case BelongsTo:
+		found, err = dao.findAllModelsBy(withoutDeleted, relatedKey, fieldValues(pModels, pMeta, pAssociation.ForeignKey)...)
+		children = groupBy(found, related, relatedKey)
+		key = pAssociation.ForeignKey
+	case HasOne, HasMany:
+		found, err = dao.findAllModelsBy(withoutDeleted, pAssociation.ForeignKey, fieldValues(pModels, pMeta, key)...)
+		children = groupBy(found, related, pAssociation.ForeignKey)
+	case ManyToMany:
+		links, keys, linkErr := o.links(pCtx, pAssociation.Through, fieldValues(pModels, pMeta, key))
+		if linkErr != nil {
+			return nil, linkErr
+		}
+		found, err = dao.findAllModelsBy(withoutDeleted, relatedKey, keys...)
+		byKey := groupBy(found, related, relatedKey)
+		for parent, linked := range links {
+			for _, child := range linked {
+				children[parent] = append(children[parent], byKey[child]...)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, model := range pModels {
+		if associator, ok := model.(Associator); ok {
+			associator.Associate(pAssociation.Name, children[mapKey(fieldValue(model, pMeta, key))])
+		}
+	}
+	return found, nil
+}
+
+// Reads the links of the keys from a join table returning the
+// linked keys of each key and all the distinct linked keys
+func (o *Gem) links(pCtx context.Context, pJoin JoinTable, pKeys []interface{}) (map[interface{}][]interface{}, []interface{}, error) {
+	links := make(map[interface{}][]interface{})
+	if len(pKeys) == 0 {
+		return links, nil, nil
+	}
+	var keys []interface{}
+	seen := make(map[interface{}]bool)
+	for _, batch := range batches(pKeys, maxBindArgs(o.Dialect)) {
+		builder := &SqlBuilder{Dialect: o.Dialect}
+		rows, err := o.query(pCtx, builder.SelectLinks(pJoin, len(batch)).Sql().String(), batch...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var key, linked interface{}
+			rows.Scan(&key, &linked)
+			links[mapKey(key)] = append(links[mapKey(key)], mapKey(linked))
+			if !seen[mapKey(linked)] {
+				seen[mapKey(linked)] = true
+				keys = append(keys, linked)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return links, keys, nil
+}
+
+// Gets the distinct values of a field across the Models
+// leaving out nulls
+func fieldValues(pModels []Model, pMeta ModelMetadata, pField string) (values []interface{}) {
+	seen := make(map[interface{}]bool)
+	for _, model := range pModels {
+		value := fieldValue(model, pMeta, pField)
+		if value != nil && !seen[mapKey(value)] {
+			seen[mapKey(value)] = true
+			values = append(values, value)
+		}
+	}
+	return
+}
+
+// Groups the Models by the value of a field
+func groupBy(pModels []Model, pMeta ModelMetadata, pField string) map[interface{}][]Model {
+	groups := make(map[interface{}][]Model)
+	for _, model := range pModels {
+		key := mapKey(fieldValue(model, pMeta, pField))
+		groups[key] = append(groups[key], model)
+	}
+	return groups
+}
+
+// Gets the driver value of a Model's field
+func fieldValue(pModel Model, pMeta ModelMetadata, pField string) driver.Value {
+	for i, column := range pMeta.orderedColumns() {
+		if column.Identifier == pField {
+			value, _ := BindArgs(pModel)[i].(driver.Valuer).Value()
+			return value
+		}
+	}
+	panic(fmt.Sprintf("Opal.Preload: %s has no field %s", pMeta.table.Name, pField))
+}
+
+// Sets a Model's field to a driver value or to null when nil
+func setField(pModel Model, pMeta ModelMetadata, pField string, pValue driver.Value) {
+	for i, column := range pMeta.orderedColumns() {
+		if column.Identifier == pField {
+			setArg(BindArgs(pModel)[i], pValue)
+			return
+		}
+	}
+	panic(fmt.Sprintf("Opal.Cascade: %s has no field %s", pMeta.table.Name, pField))
+}
+
+// Sets a bind arg to a driver value or to null when nil
+func setArg(pArg interface{}, pValue driver.Value) {
+	if pValue == nil {
+		field := reflect.ValueOf(pArg).Elem()
+		field.Set(reflect.Zero(field.Type()))
+		return
+	}
+	pArg.(sql.Scanner).Scan(pValue)
+}
+
+// Byte slices cannot key a map so are keyed by their string
+func mapKey(pValue interface{}) interface{} {
+	if b, ok := pValue.([]byte); ok {
+		return string(b)
+	}
+	return pValue
+}
+
+// ******************************************** Cascades
+
+// The writes an association can cascade to its related Models.
+// Deletes either remove the related Models or null their foreign
+// keys and only cascade from the side the foreign key references.
+// Any cascade of a ManyToMany maintains its join table.
+const (
+	CascadeSave    = "save"
+	CascadeDelete  = "delete"
+	CascadeNullify = "nullify"
+)
+
+// Writes the Model cascading the save to its associations
+func (o *Gem) saveModel(pExecor Execor, pModel Model, fWrite func(Execor, Model) Result) Result {
+	o.bound(pModel)
+	if !o.allModelsMetadata[pModel.ModelName()].cascades(CascadeSave) {
+		return fWrite(pExecor, pModel)
+	}
+	return o.cascade(pExecor, pModel, func(pExecor Execor, pModel Model) Result {
+		return o.saveCascade(pExecor, pModel, fWrite)
+	})
+}
+
+// Deletes the Model cascading the delete to its associations
+func (o *Gem) deleteModel(pExecor Execor, pModel Model, fRemove func(Execor, Model) Result, pChildren deletedScope) Result {
+	o.bound(pModel)
+	if !o.allModelsMetadata[pModel.ModelName()].cascades(CascadeDelete, CascadeNullify) {
+		return fRemove(pExecor, pModel)
+	}
+	return o.cascade(pExecor, pModel, func(pExecor Execor, pModel Model) Result {
+		return o.deleteCascade(pExecor, pModel, fRemove, pChildren)
+	})
+}
+
+// Runs a cascading write inside a transaction unless one is
+// already active so it applies to all the Models or none
+func (o *Gem) cascade(pExecor Execor, pModel Model, fCascade func(Execor, Model) Result) Result {
+	if txFromContext(pExecor.ExecorContext()) != nil {
+		return fCascade(pExecor, pModel)
+	}
+	var result Result
+	txResult, ok := o.BeginContext(pExecor.ExecorContext(), func(pTx Transaction) Result {
+		result = fCascade(pTx.Txn, pModel)
+		return result
+	}).Go()
+	if !ok && result.Error == nil {
+		result.Error = txResult.Error
+	}
+	return result
+}
+
+// Inserts the Model with its own args
+func insertModel(pExecor Execor, pModel Model) Result {
+	return persist(pExecor, pModel)
+}
+
+// Inserts a new Model or updates an existing one
+func (o *Gem) save(pExecor Execor, pModel Model) Result {
+	if IsNew(pModel) {
+		return persist(pExecor, pModel)
+	}
+	return o.merge(pExecor, pModel)
+}
+
+// Writes the Model and cascades the save to the loaded Models of
+// its associations. Referenced Models are saved first so the Model
+// can hold their keys and referencing Models after so they can
+// hold the Model's key.
+func (o *Gem) saveCascade(pExecor Execor, pModel Model, fWrite func(Execor, Model) Result) Result {
+	meta := o.allModelsMetadata[o.bound(pModel).ModelName()]
+	associator, ok := pModel.(Associator)
+	if !ok {
+		return fWrite(pExecor, pModel)
+	}
+	for _, association := range meta.associations {
+		if association.Kind != BelongsTo || !association.cascades(CascadeSave) {
+			continue
+		}
+		for _, parent := range associator.Associated(association.Name) {
+			if result := o.saveCascade(pExecor, parent, o.save); result.Error != nil {
+				return result
+			}
+			related := o.allModelsMetadata[association.Model]
+			setField(pModel, meta, association.ForeignKey, fieldValue(parent, related, related.Keys()[0].Identifier))
+		}
+	}
+	result := fWrite(pExecor, pModel)
+	if result.Error != nil {
+		return result
+	}
+	for _, association := range meta.associations {
+		if association.Kind == BelongsTo || !association.cascades(CascadeSave) {
+			continue
+		}
+		children := associator.Associated(association.Name)
+		if children == nil {
+			continue
+		}
+		key := fieldValue(pModel, meta, meta.Keys()[0].Identifier)
+		related := o.allModelsMetadata[association.Model]
+		for _, child := range children {
+			if association.Kind != ManyToMany {
+				setField(child, related, association.ForeignKey, key)
+			}
+			if result := o.saveCascade(pExecor, child, o.save); result.Error != nil {
+				return result
+			}
+		}
+		if association.Kind == ManyToMany {
+			if result := relink(pExecor, pModel, association.Name, children); result.Error != nil {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// Replaces the links of a ManyToMany with the Models
+func relink(pExecor Execor, pModel Model, pAssociation string, pRelated []Model) Result {
+	if result := unlinkEach(pExecor, pModel, pAssociation); result.Error != nil {
+		return result
+	}
+	return linkEach(pExecor, pModel, associationStmt(pAssociation, link), pRelated)
+}
+
+// Deletes the Model after cascading the delete to the Models which
+// reference it. They are found in the data-store rather than those
+// loaded so none are left behind. A hard delete finds the soft
+// deleted ones too as their rows still reference the Model.
+func (o *Gem) deleteCascade(pExecor Execor, pModel Model, fRemove func(Execor, Model) Result, pChildren deletedScope) Result {
+	meta := o.allModelsMetadata[pModel.ModelName()]
+	for _, association := range meta.associations {
+		if !association.cascades(CascadeDelete, CascadeNullify) {
+			continue
+		}
+		if association.Kind == ManyToMany {
+			if result := unlinkEach(pExecor, pModel, association.Name); result.Error != nil {
+				return result
+			}
+			continue
+		}
+		key := fieldValue(pModel, meta, meta.Keys()[0].Identifier)
+		related := o.allModelsMetadata[association.Model]
+		dao := &ModelIDAO{gem: o, model: association.Model, ctx: pExecor.ExecorContext()}
+		children, err := dao.findAllModelsBy(pChildren, association.ForeignKey, key)
+		if err != nil {
+			return Result{Error: err}
+		}
+		for _, child := range children {
+			var result Result
+			if association.cascades(CascadeDelete) {
+				result = o.deleteCascade(pExecor, child, fRemove, pChildren)
+			} else {
+				setField(child, related, association.ForeignKey, nil)
+				result = o.merge(pExecor, child)
+			}
+			if result.Error != nil {
+				return result
+			}
+		}
+		if associator, ok := pModel.(Associator); ok {
+			associator.Associate(association.Name, nil)
+		}
+	}
+	return fRemove(pExecor, pModel)
+}
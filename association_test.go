@@ -0,0 +1,351 @@
+package opal
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Inserts people each owning a number of pets which each own a toy
+func seedPets(t *testing.T, pGem *Gem, pPeople, pPets int) {
+	people := &ModelIDAO{gem: pGem, model: personModel}
+	pets := &ModelIDAO{gem: pGem, model: petModel}
+	toys := &ModelIDAO{gem: pGem, model: toyModel}
+	for i := 0; i < pPeople; i++ {
+		o := rawPerson()
+		o.Name = NewString(fmt.Sprintf("person %d", i))
+		if result := people.Insert(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		for j := 0; j < pPets; j++ {
+			p := rawPet()
+			p.Name = NewString(fmt.Sprintf("pet %d.%d", i, j))
+			p.OwnerId = NewInt64(o.Id.Primitive())
+			if result := pets.Insert(p); result.Error != nil {
+				t.Fatal(result.Error)
+			}
+			toy := rawToy()
+			toy.Name = NewString(fmt.Sprintf("toy %d.%d", i, j))
+			toy.PetId = NewInt64(p.Id.Primitive())
+			if result := toys.Insert(toy); result.Error != nil {
+				t.Fatal(result.Error)
+			}
+		}
+	}
+}
+
+// limitDialect binds a number of args a statement at most
+type limitDialect struct {
+	testDialect
+	max int
+}
+
+func (o limitDialect) MaxBindArgs() int {
+	return o.max
+}
+
+func TestPreloadHasMany(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	seedPets(t, gem, 1000, 2)
+
+	db.reset()
+	people := (&ModelIDAO{gem: gem, model: personModel}).FindAllModels(Preload("Pets"))
+	if len(db.executed) != 2 {
+		t.Fatalf("executed %d statements, want 2", len(db.executed))
+	}
+	if len(people) != 1000 {
+		t.Fatalf("found %d people, want 1000", len(people))
+	}
+	for i, model := range people {
+		o := model.(*person)
+		if len(o.pets) != 2 {
+			t.Fatalf("person %d has %d pets, want 2", i, len(o.pets))
+		}
+		for j, p := range o.pets {
+			if want := fmt.Sprintf("pet %d.%d", i, j); p.Name.String() != want || p.OwnerId.Primitive() != o.Id.Primitive() {
+				t.Errorf("person %d pet %d = %s owned by %d, want %s", i, j, p.Name.String(), p.OwnerId.Primitive(), want)
+			}
+		}
+	}
+
+	// A Dialect which binds fewer args has the keys found in batches
+	gem, db = testDialectGem(t, limitDialect{max: 999}, new(person), new(pet), new(toy))
+	seedPets(t, gem, 1000, 2)
+	db.reset()
+	people = (&ModelIDAO{gem: gem, model: personModel}).FindAllModels(Preload("Pets"))
+	if len(db.executed) != 3 || placeholders(db.executed[1]) != 999 || placeholders(db.executed[2]) != 1 {
+		t.Fatalf("executed %d statements, want the people and two batches of pets", len(db.executed))
+	}
+	if len(people) != 1000 || len(people[999].(*person).pets) != 2 {
+		t.Errorf("found %d people, want 1000 each with their pets", len(people))
+	}
+}
+
+func TestPreloadNested(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	seedPets(t, gem, 1000, 3)
+
+	db.reset()
+	model := (&ModelIDAO{gem: gem, model: personModel}).FindModelWith([]interface{}{NewAutoIncrement(2)}, Preload("Pets.Toys"))
+	if len(db.executed) != 3 {
+		t.Errorf("executed %q, want a query per level", db.executed)
+	}
+	o := model.(*person)
+	if len(o.pets) != 3 {
+		t.Fatalf("person has %d pets, want 3", len(o.pets))
+	}
+	for j, p := range o.pets {
+		if len(p.toys) != 1 || p.toys[0].Name.String() != fmt.Sprintf("toy 1.%d", j) {
+			t.Errorf("pet %d toys = %v", j, p.toys)
+		}
+	}
+
+	// The same association is loaded once for several paths
+	db.reset()
+	people := (&ModelIDAO{gem: gem, model: personModel}).FindAllModels(Preload("Pets", "Pets.Toys"))
+	if len(db.executed) != 3 {
+		t.Errorf("executed %d statements, want a query per level", len(db.executed))
+	}
+	if o := people[999].(*person); len(o.pets) != 3 || len(o.pets[2].toys) != 1 {
+		t.Errorf("person 999 = %v, want its pets and their toys", o)
+	}
+}
+
+func TestPreloadBelongsTo(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	seedPets(t, gem, 10, 2)
+
+	db.reset()
+	pets := (&ModelIDAO{gem: gem, model: petModel}).FindAllModels(Preload("Owner"))
+	if len(db.executed) != 2 {
+		t.Errorf("executed %q, want 2 statements", db.executed)
+	}
+	for _, model := range pets {
+		p := model.(*pet)
+		if p.owner == nil || p.owner.Id.Primitive() != p.OwnerId.Primitive() {
+			t.Errorf("pet %s owner = %v, want person %d", p.Name.String(), p.owner, p.OwnerId.Primitive())
+		}
+	}
+}
+
+func TestPreloadManyToMany(t *testing.T) {
+	for _, test := range []struct {
+		dialect  Dialect
+		executed int
+	}{{testDialect{}, 3}, {limitDialect{max: 2}, 5}} {
+		gem, db := testDialectGem(t, test.dialect, new(article), new(tag))
+		articles := &ModelIDAO{gem: gem, model: articleModel}
+		tags := &ModelIDAO{gem: gem, model: tagModel}
+		var all []Model
+		for _, name := range []string{"go", "sql", "orm"} {
+			o := rawTag()
+			o.Name = NewString(name)
+			tags.Insert(o)
+			all = append(all, o)
+		}
+		for i := 0; i < 3; i++ {
+			o := rawArticle()
+			o.Title = NewString(fmt.Sprintf("article %d", i))
+			articles.Insert(o)
+			articles.Link(o, "Tags", all[i:]...)
+		}
+
+		// The links and tags are found in batches the Dialect binds
+		db.reset()
+		found := articles.FindAllModels(Preload("Tags"))
+		if len(db.executed) != test.executed {
+			t.Errorf("%v: executed %q, want %d statements", test.dialect, db.executed, test.executed)
+		}
+		for i, model := range found {
+			o := model.(*article)
+			if len(o.tags) != 3-i || o.tags[0].Name.String() != all[i].(*tag).Name.String() {
+				t.Errorf("%v: article %d tags = %v", test.dialect, i, o.tags)
+			}
+		}
+
+		// A batch of links which fails fails the find
+		if len(db.executed) == 5 {
+			db.fail(db.executed[2])
+			if found := articles.FindAllModels(Preload("Tags")); found != nil {
+				t.Errorf("FindAllModels() = %v after a batch of links failed, want nil", found)
+			}
+			db.reset()
+		}
+	}
+}
+
+// Counts the rows of a table
+func rows(pDB *fakeDB, pTable string) int {
+	pDB.mu.Lock()
+	defer pDB.mu.Unlock()
+	return len(pDB.tables[pTable].rows)
+}
+
+func TestCascadeSave(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	o := rawPerson()
+	o.Name = NewString("Ann")
+	rex := rawPet()
+	rex.Name = NewString("Rex")
+	ball := rawToy()
+	ball.Name = NewString("ball")
+	rex.toys = []*toy{ball}
+	o.pets = []*pet{rex}
+
+	if result := (&ModelIDAO{gem: gem, model: personModel}).Insert(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if db.count("BEGIN") != 1 || db.count("COMMIT") != 1 || db.count("INSERT") != 3 {
+		t.Errorf("executed %q, want three inserts in one transaction", db.executed)
+	}
+	if rex.OwnerId.Primitive() != o.Id.Primitive() || ball.PetId.Primitive() != rex.Id.Primitive() {
+		t.Errorf("foreign keys = %d and %d, want the saved keys", rex.OwnerId.Primitive(), ball.PetId.Primitive())
+	}
+
+	// Saving again updates the changed children and inserts new ones
+	db.reset()
+	rex.Name = NewString("Rexy")
+	o.pets = append(o.pets, rawPet())
+	if result := (&ModelIDAO{gem: gem, model: personModel}).Save(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if db.count("UPDATE") != 1 || db.count("INSERT") != 1 || rows(db, "pets") != 2 {
+		t.Errorf("executed %q, want the pet updated and a pet inserted", db.executed)
+	}
+}
+
+func TestCascadeDelete(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	seedPets(t, gem, 2, 2)
+	people := &ModelIDAO{gem: gem, model: personModel}
+
+	db.reset()
+	if result := people.Delete(people.FindModel(NewAutoIncrement(1))); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if rows(db, "people") != 1 || rows(db, "pets") != 2 || rows(db, "toys") != 4 {
+		t.Errorf("rows = %d people, %d pets, %d toys, want the person and pets removed",
+			rows(db, "people"), rows(db, "pets"), rows(db, "toys"))
+	}
+	toys := (&ModelIDAO{gem: gem, model: toyModel}).FindAllModels()
+	for _, model := range toys[:2] {
+		if value, _ := model.(*toy).PetId.Value(); value != nil {
+			t.Errorf("toy %s PetId = %v, want null", model.(*toy).Name.String(), value)
+		}
+	}
+	if db.count("BEGIN") != 1 || db.count("COMMIT") != 1 {
+		t.Errorf("executed %q, want one transaction", db.executed)
+	}
+
+	// A failing hook rolls back every cascaded write
+	pets := &ModelIDAO{gem: gem, model: petModel}
+	p := pets.FindModel(NewAutoIncrement(4)).(*pet)
+	p.Name = NewString("undeletable")
+	pets.Save(p)
+	result := people.Delete(people.FindModel(NewAutoIncrement(2)))
+	if result.Error == nil || result.Error.Error() != "pet is undeletable" {
+		t.Errorf("Delete() error = %v, want the hook error", result.Error)
+	}
+	if rows(db, "people") != 1 || rows(db, "pets") != 2 || db.count("ROLLBACK") != 1 {
+		t.Errorf("executed %q, want the delete rolled back", db.executed)
+	}
+
+	// So does failing to find the Models to cascade to
+	db.reset()
+	db.fail("SELECT Id, Name, OwnerId FROM pets")
+	result = people.Delete(people.FindModel(NewAutoIncrement(2)))
+	if result.Error == nil || rows(db, "people") != 1 || db.count("DELETE") != 0 || db.count("ROLLBACK") != 1 {
+		t.Errorf("Delete() error = %v executing %q, want the failed find to roll back", result.Error, db.executed)
+	}
+	db.reset()
+}
+
+func TestCascadeSaveCompoundKey(t *testing.T) {
+	gem, db := testGem(t, new(sale), new(saleLine))
+	sales := &ModelIDAO{gem: gem, model: saleModel}
+	o := rawSale()
+	o.Buyer = NewString("Ann")
+	for i, item := range []string{"tea", "cake"} {
+		line := rawSaleLine()
+		line.LineNo = NewInt64(int64(i + 1))
+		line.Item = NewString(item)
+		o.lines = append(o.lines, line)
+	}
+
+	// The lines have their keys set but are new so are inserted
+	if result := sales.Insert(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if rows(db, "sale_lines") != 2 || db.count("INSERT INTO sale_lines") != 2 {
+		t.Fatalf("executed %q, want the lines inserted", db.executed)
+	}
+
+	// Once inserted they are updated
+	db.reset()
+	o.lines[1].Item = NewString("scone")
+	o.lines = append(o.lines, rawSaleLine())
+	o.lines[2].LineNo = NewInt64(3)
+	if result := sales.Save(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if rows(db, "sale_lines") != 3 || db.count("UPDATE sale_lines") != 1 || db.count("INSERT INTO sale_lines") != 1 {
+		t.Errorf("executed %q, want the changed line updated and the new one inserted", db.executed)
+	}
+	found := (&ModelIDAO{gem: gem, model: saleLineModel}).FindModel(o.Id.Primitive(), int64(2)).(*saleLine)
+	if found.Item.String() != "scone" {
+		t.Errorf("found line 2 item %q, want scone", found.Item.String())
+	}
+}
+
+func TestCascadeHardDelete(t *testing.T) {
+	gem, db := testGem(t, new(thread), new(reply))
+	threads := &ModelIDAO{gem: gem, model: threadModel}
+	replies := &ModelIDAO{gem: gem, model: replyModel}
+	o := rawThread()
+	o.Title = NewString("thread")
+	threads.Insert(o)
+	for _, body := range []string{"kept", "deleted"} {
+		r := rawReply()
+		r.Body = NewString(body)
+		r.ThreadId = NewInt64(o.Id.Primitive())
+		replies.Insert(r)
+		if body == "deleted" {
+			replies.Delete(r)
+		}
+	}
+
+	// The soft deleted reply still references the thread so goes too
+	if result := threads.HardDelete(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if rows(db, "threads") != 0 || rows(db, "replies") != 0 {
+		t.Errorf("rows = %d threads, %d replies, want all removed", rows(db, "threads"), rows(db, "replies"))
+	}
+}
+
+func TestCascadeManyToMany(t *testing.T) {
+	gem, db := testGem(t, new(article), new(tag))
+	articles := &ModelIDAO{gem: gem, model: articleModel}
+	a := rawArticle()
+	for _, name := range []string{"go", "sql"} {
+		o := rawTag()
+		o.Name = NewString(name)
+		a.tags = append(a.tags, o)
+	}
+	if result := articles.Insert(a); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if rows(db, "tags") != 2 || rows(db, "articles_tags") != 2 {
+		t.Errorf("rows = %d tags, %d links, want the tags saved and linked", rows(db, "tags"), rows(db, "articles_tags"))
+	}
+
+	a.tags = a.tags[1:]
+	articles.Save(a)
+	if found := articles.FindAllLinked(a, "Tags"); len(found) != 1 || found[0].(*tag).Name.String() != "sql" {
+		t.Errorf("FindAllLinked() = %v, want the links replaced", found)
+	}
+
+	articles.Delete(a)
+	if rows(db, "tags") != 2 || rows(db, "articles_tags") != 0 {
+		t.Errorf("rows = %d tags, %d links, want only the links removed", rows(db, "tags"), rows(db, "articles_tags"))
+	}
+}
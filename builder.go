@@ -6,14 +6,35 @@ package opal
 
 import (
 	"bytes"
+	"fmt"
 )
 
 type SqlBuilder struct {
 	*ModelMetadata
 	Dialect
 	bytes.Buffer
+
+	// Selects, updates and deletes are scoped by the Model's
+	// scopes and selects to the rows which are not soft deleted
+	// unless another deleted scope is chosen
+	selecting bool
+	scoping   bool
+	filtered  bool
+	deleted   deletedScope
+
+	// Whether the values of the Model's scopes are bound
+	bindsScopes bool
 }
 
+// The soft deleted rows a select includes
+type deletedScope int
+
+const (
+	withoutDeleted deletedScope = iota
+	withDeleted
+	onlyDeleted
+)
+
 func (o *SqlBuilder) Add(p string) *SqlBuilder {
 	o.WriteString(p)
 	return o
@@ -28,13 +49,30 @@ func (o *SqlBuilder) Create() *SqlBuilder {
 	return o.Add("(").With(o.ColumnListWithConstraints, o.EncodeIdentifier).Add(")")
 }
 
+// Creates a secondary index on the Model's table
+func (o *SqlBuilder) CreateIndex(pIndex Index) *SqlBuilder {
+	o.Add("CREATE ")
+	if pIndex.Unique {
+		o.Add("UNIQUE ")
+	}
+	o.Add("INDEX IF NOT EXISTS ").Add(pIndex.Name).Add(" ON ").Add(o.table.Name).Add("(")
+	for _, column := range pIndex.Columns {
+		o.Add(column).Add(", ")
+	}
+	return o.Truncate(2).Add(")")
+}
+
+// Selects the columns by name in the order Models scan them
+// so the results do not depend on the table's column order
 func (o *SqlBuilder) Select(pColumns ...string) *SqlBuilder {
-	return o.Add("SELECT * FROM ").Add(o.table.Name)
+	o.selecting, o.scoping = true, true
+	o.Add("SELECT ").With(o.ColumnsList, o.EncodeIdentifier)
+	return o.Add(" FROM ").Add(o.table.Name)
 }
 
 func (o *SqlBuilder) Insert() *SqlBuilder {
 	o.Add("INSERT INTO ").Add(o.table.Name)
-	return o.Add("(").With(o.ColumnsList, o.EncodeIdentifier).Add(")")
+	return o.Add("(").With(o.InsertableColumnsList, o.EncodeIdentifier).Add(")")
 }
 
 func (o *SqlBuilder) With(fBuild SqlBuilderDialectEncoder, fDialect DialectEncoder) *SqlBuilder {
@@ -42,20 +80,105 @@ func (o *SqlBuilder) With(fBuild SqlBuilderDialectEncoder, fDialect DialectEncod
 }
 
 func (o *SqlBuilder) Values() *SqlBuilder {
-	return o.Add(" VALUES (").With(o.ColumnsBindList, o.EncodeIdentifier).Add(")")
+	return o.Add(" VALUES (").With(o.InsertableColumnsBindList, o.EncodeIdentifier).Add(")")
+}
+
+// Returns the key and generated columns from an insert
+func (o *SqlBuilder) ReturningInserted() *SqlBuilder {
+	return o.Add(" RETURNING ").With(o.InsertReturnList, o.EncodeIdentifier)
+}
+
+// Returns the generated columns from an update
+func (o *SqlBuilder) ReturningGenerated() *SqlBuilder {
+	o.scope()
+	return o.Add(" RETURNING ").With(o.GeneratedList, o.EncodeIdentifier)
 }
 
 func (o *SqlBuilder) Update() *SqlBuilder {
+	o.scoping = true
 	o.Add("UPDATE ").Add(o.table.Name)
-	return o.Add(" SET ").With(o.NonKeyListEqualsNonKeyBindList, o.EncodeIdentifier)
+	return o.Add(" SET ").With(o.UpdatableListEqualsUpdatableBindList, o.EncodeIdentifier)
+}
+
+// Updates only the columns of the fields
+func (o *SqlBuilder) UpdateFields(pFields ...string) *SqlBuilder {
+	o.scoping = true
+	o.Add("UPDATE ").Add(o.table.Name).Add(" SET ")
+	for _, field := range pFields {
+		o.Add(o.Column(field).Name).Add(" = ?, ")
+	}
+	return o.Truncate(2)
 }
 
 func (o *SqlBuilder) Delete() *SqlBuilder {
+	o.scoping = true
 	return o.Add("DELETE FROM ").Add(o.table.Name)
 }
 
+// Soft deletes by setting the DeletedAt column
+func (o *SqlBuilder) SoftDelete() *SqlBuilder {
+	column, _ := o.deletedColumn()
+	o.scoping = true
+	return o.Add("UPDATE ").Add(o.table.Name).Add(" SET ").Add(column.Name).Add(" = ?")
+}
+
+// Includes soft deleted rows in a select
+func (o *SqlBuilder) WithDeleted() *SqlBuilder {
+	o.deleted = withDeleted
+	return o
+}
+
+// Scopes a select to the soft deleted rows only
+func (o *SqlBuilder) OnlyDeleted() *SqlBuilder {
+	o.deleted = onlyDeleted
+	return o
+}
+
+// Chooses the deleted scope of a select
+func (o *SqlBuilder) withScope(pScope deletedScope) *SqlBuilder {
+	o.deleted = pScope
+	return o
+}
+
+// Adds the conditions of the Model's scopes, which bind after
+// any other args, and of the deleted scope of a select. They
+// are only added once.
+func (o *SqlBuilder) scope() {
+	if !o.scoping {
+		return
+	}
+	o.scoping = false
+	o.bindsScopes = len(o.scopes) > 0
+	var conditions []string
+	for _, scope := range o.scopes {
+		conditions = append(conditions, o.Column(scope.Field).Name+" = ?")
+	}
+	if column, ok := o.deletedColumn(); ok && o.selecting {
+		switch o.deleted {
+		case withoutDeleted:
+			conditions = append(conditions, column.Name+" IS NULL")
+		case onlyDeleted:
+			conditions = append(conditions, column.Name+" IS NOT NULL")
+		}
+	}
+	for _, condition := range conditions {
+		if o.filtered {
+			o.Add(" AND ")
+		} else {
+			o.where()
+		}
+		o.Add(condition)
+	}
+}
+
+// Starts the conditions of a statement
+func (o *SqlBuilder) where() *SqlBuilder {
+	o.filtered = true
+	return o.Add(" WHERE ")
+}
+
 func (o *SqlBuilder) Where(pMap Mapper) *SqlBuilder {
-	o.Add(" WHERE ")
+	o.where()
 	// TODO must be at least one value
 	// excludes null
 	for _, column := range o.columns {
@@ -71,11 +194,70 @@ type Mapper interface {
 }
 
 func (o *SqlBuilder) WherePk() *SqlBuilder {
-	return o.Add(" WHERE ").With(o.KeyListEqualsKeyBindList, o.EncodeIdentifier)
+	return o.where().With(o.KeyListEqualsKeyBindList, o.EncodeIdentifier)
+}
+
+// Matches the version a versioned Model was read with
+func (o *SqlBuilder) WhereVersion() *SqlBuilder {
+	if column, ok := o.versionColumn(); ok {
+		o.Add(" AND ").Add(column.Name).Add(" = ?")
+	}
+	return o
+}
+
+// Matches the column of a field against a number of values
+func (o *SqlBuilder) WhereIn(pField string, pCount int) *SqlBuilder {
+	position, ok := o.columnsByFieldName[pField]
+	if !ok {
+		panic(fmt.Sprintf("Opal.SqlBuilder: %s has no field %s", o.table.Name, pField))
+	}
+	return o.where().Add(o.columns[position].Name).in(pCount)
+}
+
+// Adds a comparison to one or more bind values
+func (o *SqlBuilder) in(pCount int) *SqlBuilder {
+	if pCount == 1 {
+		return o.Add(" = ?")
+	}
+	o.Add(" IN (")
+	for i := 0; i < pCount; i++ {
+		o.Add("?, ")
+	}
+	return o.Truncate(2).Add(")")
+}
+
+// Matches the Models linked through a join table to the key
+// of a Model on its other side
+func (o *SqlBuilder) WhereLinked(pJoin JoinTable) *SqlBuilder {
+	o.where().Add(o.Keys()[0].Name)
+	o.Add(" IN (SELECT ").Add(pJoin.ForeignKey).Add(" FROM ").Add(pJoin.Name)
+	return o.Add(" WHERE ").Add(pJoin.Key).Add(" = ?)")
+}
+
+// Selects the links of a number of Models from a join table
+func (o *SqlBuilder) SelectLinks(pJoin JoinTable, pCount int) *SqlBuilder {
+	o.Add("SELECT ").Add(pJoin.Key).Add(", ").Add(pJoin.ForeignKey).Add(" FROM ").Add(pJoin.Name)
+	return o.Add(" WHERE ").Add(pJoin.Key).in(pCount)
+}
+
+// Links a Model to a related Model in a join table
+func (o *SqlBuilder) Link(pJoin JoinTable) *SqlBuilder {
+	o.Add("INSERT INTO ").Add(pJoin.Name)
+	return o.Add("(").Add(pJoin.Key).Add(", ").Add(pJoin.ForeignKey).Add(") VALUES (?, ?)")
+}
+
+// Unlinks a Model from a related Model in a join table
+func (o *SqlBuilder) Unlink(pJoin JoinTable) *SqlBuilder {
+	return o.UnlinkAll(pJoin).Add(" AND ").Add(pJoin.ForeignKey).Add(" = ?")
+}
+
+// Unlinks a Model from all of its related Models in a join table
+func (o *SqlBuilder) UnlinkAll(pJoin JoinTable) *SqlBuilder {
+	return o.Add("DELETE FROM ").Add(pJoin.Name).Add(" WHERE ").Add(pJoin.Key).Add(" = ?")
 }
 
 func (o *SqlBuilder) WhereAll() *SqlBuilder {
-	return o.Add(" WHERE ").With(o.ColumnsListEqualsColumnsBindList, o.EncodeIdentifier)
+	return o.where().With(o.ColumnsListEqualsColumnsBindList, o.EncodeIdentifier)
 }
 
 func (o *SqlBuilder) Truncate(pInt int) *SqlBuilder {
@@ -84,7 +266,13 @@ func (o *SqlBuilder) Truncate(pInt int) *SqlBuilder {
 }
 
 func (o *SqlBuilder) Sql() Sql {
+	o.scope()
 	sql := OpalSql(o.Buffer.String())
 	o.Reset()
+	scoped := o.bindsScopes
+	o.selecting, o.scoping, o.filtered, o.deleted, o.bindsScopes = false, false, false, withoutDeleted, false
+	if scoped {
+		return &scopedSql{sql, o.scopes}
+	}
 	return &sql
 }
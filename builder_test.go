@@ -0,0 +1,86 @@
+package opal
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testDialect struct{}
+
+func (testDialect) EncodeIdentifier(pIdentifier string) string {
+	return pIdentifier
+}
+
+func (testDialect) TransformTypeDeclaration(pColumn Column) string {
+	return pColumn.ToSqlType()
+}
+
+type orderLine struct {
+	Entity
+	LineNo  Int64
+	OrderId Int64
+	Item    String
+}
+
+// Builds metadata the way a generated Gather would for an
+// orderLine keyed on OrderId then LineNo
+func orderLineBuilder() *SqlBuilder {
+	meta := NewMetadata(nil, reflect.TypeOf(orderLine{}))
+	meta.AddTable(Table{Name: "order_lines"}, "OrderId", "LineNo")
+	meta.AddKey("OrderId", 2, Column{Name: "OrderId"}, reflect.Int64)
+	meta.AddKey("LineNo", 1, Column{Name: "LineNo"}, reflect.Int64)
+	meta.AddColumn("Item", 3, Column{Name: "Item"}, reflect.String)
+	return &SqlBuilder{ModelMetadata: meta, Dialect: testDialect{}}
+}
+
+func TestSqlBuilderCreateCompoundKey(t *testing.T) {
+	want := "CREATE TABLE IF NOT EXISTS order_lines(" +
+		"OrderId INTEGER NOT NULL, LineNo INTEGER NOT NULL, Item VARCHAR(255), " +
+		"PRIMARY KEY (OrderId, LineNo))"
+	if s := orderLineBuilder().Create().Sql().String(); s != want {
+		t.Errorf("Create() = %q, want %q", s, want)
+	}
+}
+
+func TestSqlBuilderWhereCompoundKey(t *testing.T) {
+	want := "DELETE FROM order_lines WHERE OrderId = ? AND LineNo = ?"
+	for i := 0; i < 10; i++ {
+		if s := orderLineBuilder().Delete().WherePk().Sql().String(); s != want {
+			t.Fatalf("Delete().WherePk() = %q, want %q", s, want)
+		}
+	}
+}
+
+// returningDialect reads back generated columns with RETURNING
+type returningDialect struct {
+	testDialect
+}
+
+func (returningDialect) SupportsReturning() bool {
+	return true
+}
+
+func TestSqlBuilderCreateIndex(t *testing.T) {
+	meta := NewMetadata(nil, reflect.TypeOf(orderLine{}))
+	meta.AddTable(Table{Name: "order_lines"}, "OrderId", "LineNo")
+	meta.AddKey("OrderId", 2, Column{Name: "OrderId"}, reflect.Int64)
+	meta.AddKey("LineNo", 1, Column{Name: "LineNo", Index: "by_line, by_line_item"}, reflect.Int64)
+	meta.AddColumn("Item", 3, Column{Name: "Item", UniqueIndex: "by_line_item"}, reflect.String)
+	want := []Index{
+		{"by_line", false, []string{"LineNo"}},
+		{"by_line_item", true, []string{"LineNo", "Item"}},
+	}
+	if indexes := meta.Indexes(); !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("Indexes() = %v, want %v", indexes, want)
+	}
+	builder := &SqlBuilder{ModelMetadata: meta, Dialect: testDialect{}}
+	sqls := []string{
+		"CREATE INDEX IF NOT EXISTS by_line ON order_lines(LineNo)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS by_line_item ON order_lines(LineNo, Item)",
+	}
+	for i, index := range meta.Indexes() {
+		if s := builder.CreateIndex(index).Sql().String(); s != sqls[i] {
+			t.Errorf("CreateIndex(%v) = %q, want %q", index, s, sqls[i])
+		}
+	}
+}
@@ -4,6 +4,11 @@
  */
 package opal
 
+import (
+	"errors"
+	"strings"
+)
+
 // The Dialect interface performs sql syntax modification to conform
 // to the differences in implementations of the SQL standard.
 // Initially the Dialect will be made with the differences of Sqlite3
@@ -38,6 +43,142 @@ type Dialect interface {
 	TransformTypeDeclaration(pColumn Column) string
 }
 
+// A ReturningDialect may be implemented by a Dialect which can
+// return columns from an INSERT or UPDATE through a RETURNING
+// clause. Other dialects read generated columns back with a follow
+// up find by key.
+type ReturningDialect interface {
+	SupportsReturning() bool
+}
+
+// Whether the Dialect supports RETURNING clauses
+func supportsReturning(pDialect Dialect) bool {
+	d, ok := pDialect.(ReturningDialect)
+	return ok && d.SupportsReturning()
+}
+
+// A SavepointDialect may be implemented by a Dialect whose
+// savepoint syntax differs from the standard SAVEPOINT, ROLLBACK TO
+// SAVEPOINT and RELEASE SAVEPOINT. An empty release is not run.
+type SavepointDialect interface {
+	Savepoint(pName string) string
+	RollbackToSavepoint(pName string) string
+	ReleaseSavepoint(pName string) string
+}
+
+// Gets the Sql which sets a savepoint
+func savepointSql(pDialect Dialect, pName string) string {
+	if d, ok := pDialect.(SavepointDialect); ok {
+		return d.Savepoint(pName)
+	}
+	return "SAVEPOINT " + pDialect.EncodeIdentifier(pName)
+}
+
+// Gets the Sql which rolls back to a savepoint
+func rollbackToSql(pDialect Dialect, pName string) string {
+	if d, ok := pDialect.(SavepointDialect); ok {
+		return d.RollbackToSavepoint(pName)
+	}
+	return "ROLLBACK TO SAVEPOINT " + pDialect.EncodeIdentifier(pName)
+}
+
+// Gets the Sql which releases a savepoint
+func releaseSql(pDialect Dialect, pName string) string {
+	if d, ok := pDialect.(SavepointDialect); ok {
+		return d.ReleaseSavepoint(pName)
+	}
+	return "RELEASE SAVEPOINT " + pDialect.EncodeIdentifier(pName)
+}
+
+// The most bind args a statement takes unless the Dialect says
+// otherwise being the limit of Sqlite since 3.32. Postgres and
+// MySql take 65535.
+const defaultMaxBindArgs = 32766
+
+// A BindLimitDialect may be implemented by a Dialect whose
+// statements take fewer bind args, such as Sqlite before 3.32
+// which takes 999. Finds by a list of values too long are split
+// into batches.
+type BindLimitDialect interface {
+	MaxBindArgs() int
+}
+
+// Gets the most bind args a statement of the Dialect takes
+func maxBindArgs(pDialect Dialect) int {
+	if d, ok := pDialect.(BindLimitDialect); ok && d.MaxBindArgs() > 0 {
+		return d.MaxBindArgs()
+	}
+	return defaultMaxBindArgs
+}
+
+// Splits the values into batches of at most the size
+func batches(pValues []interface{}, pSize int) (split [][]interface{}) {
+	if pSize < 1 {
+		pSize = 1
+	}
+	for len(pValues) > pSize {
+		split = append(split, pValues[:pSize])
+		pValues = pValues[pSize:]
+	}
+	return append(split, pValues)
+}
+
+// A RetryDialect may be implemented by a Dialect to classify the
+// errors after which a Transaction can be run again. Other dialects
+// retry the serialization failures and deadlocks of Sql state 40001
+// and 40P01 and a busy or locked Sqlite database.
+type RetryDialect interface {
+	IsRetryable(pErr error) bool
+}
+
+// Whether a Transaction which failed with the error can be retried
+func isRetryable(pDialect Dialect, pErr error) bool {
+	if pErr == nil {
+		return false
+	}
+	if d, ok := pDialect.(RetryDialect); ok {
+		return d.IsRetryable(pErr)
+	}
+	var state interface {
+		SQLState() string
+	}
+	if errors.As(pErr, &state) {
+		switch state.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+	msg := pErr.Error()
+	return strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "database is locked")
+}
+
+// A StaleDialect may be implemented by a Dialect to classify the
+// errors of a prepared statement which must be prepared again such
+// as after the schema changed. Other dialects treat as stale a
+// closed statement, Sql state 0A000 of a cached plan and a changed
+// Sqlite schema.
+type StaleDialect interface {
+	IsStale(pErr error) bool
+}
+
+// Whether a statement which failed with the error is stale
+func isStale(pDialect Dialect, pErr error) bool {
+	if pErr == nil {
+		return false
+	}
+	if d, ok := pDialect.(StaleDialect); ok {
+		return d.IsStale(pErr)
+	}
+	var state interface {
+		SQLState() string
+	}
+	if errors.As(pErr, &state) && state.SQLState() == "0A000" {
+		return strings.Contains(pErr.Error(), "cached plan")
+	}
+	msg := pErr.Error()
+	return strings.Contains(msg, "statement is closed") || strings.Contains(msg, "SQLITE_SCHEMA") || strings.Contains(msg, "schema has changed")
+}
+
 type DialectEncoder (func(string) string)
 
 // Sqlite3 implements the Dialect interface
@@ -1,6 +1,8 @@
 package opal
 
 import (
+	"context"
+	"database/sql/driver"
 	"fmt"
 )
 
@@ -25,6 +27,9 @@ type Entity interface {
 	// of the main DAO
 	ActiveRecord() ActiveRecordDAO
 
+	// Returns the Gem the Entity is bound to
+	Gem() *Gem
+
 	// Returns the Entity's ModelName which represents its
 	// domain or type
 	ModelName() ModelName
@@ -34,7 +39,7 @@ type Entity interface {
 	Model() Model
 
 	// Each creation of a Model requires its own Entity
-	// Creating a new Entity is based on the Domain's
+	// Creating a new Entity is based on the Gem's
 	// default Entity for the Model which is created
 	// through the NewEntity func passed at GEM startup.
 	// The creation links the new Domain Entity with a
@@ -54,9 +59,28 @@ type Entity interface {
 	// the data-store
 	Delete() Result
 
+	// The writes above run under a context which cancels
+	// them and is given to the Model's hooks
+	InsertContext(pCtx context.Context) Result
+	SaveContext(pCtx context.Context) Result
+	DeleteContext(pCtx context.Context) Result
+
 	// Metadata gets a copy of the Model's metadata
 	Metadata() ModelMetadata
 
+	// Snapshot records the Model's values as those in the
+	// data-store. Opal takes one whenever a Model is scanned,
+	// inserted or updated.
+	Snapshot()
+
+	// IsDirty reports whether the Model has changed since
+	// its last snapshot
+	IsDirty() bool
+
+	// DirtyFields gets the fields which have changed since
+	// the last snapshot or every field when there is none
+	DirtyFields() []string
+
 	// String returns a string representation of the Model
 	String() string
 
@@ -69,25 +93,28 @@ type Entity interface {
 // a function through which to create the Model embeddable
 // instances of the Entity.
 type OpalEntity struct {
+	gem          *Gem
 	activeRecord ActiveRecordDAO
 	modelName    *ModelName
 	model        Model
 	metadata     *ModelMetadata
+	snapshot     []driver.Value
 }
 
 // Pass this function into the Gem to create all base Entities
 // for each Model
-func NewEntity(pModelName ModelName) Entity {
-	return &OpalEntity{currentGem.dao, &pModelName, nil, nil}
+func NewEntity(pGem *Gem, pModelName ModelName) Entity {
+	return &OpalEntity{gem: pGem, activeRecord: pGem.dao, modelName: &pModelName}
 }
 
 // TODO shrink use of instances here if possible heavy on performance
 func (o OpalEntity) New(pModel Model) Entity {
 	e := new(OpalEntity)
+	e.gem = o.gem
 	e.activeRecord = o.activeRecord
 	e.modelName = o.modelName
 	e.model = pModel
-	meta := currentGem.allModelsMetadata[*e.modelName]
+	meta := o.gem.allModelsMetadata[*e.modelName]
 	e.metadata = &meta
 	return e
 }
@@ -102,6 +129,10 @@ func (o OpalEntity) ActiveRecord() ActiveRecordDAO {
 	return o.activeRecord
 }
 
+func (o OpalEntity) Gem() *Gem {
+	return o.gem
+}
+
 func (o OpalEntity) Model() Model {
 	return o.model
 }
@@ -118,6 +149,18 @@ func (o *OpalEntity) Delete() Result {
 	return o.activeRecord.Delete(o.model)
 }
 
+func (o *OpalEntity) InsertContext(pCtx context.Context) Result {
+	return o.activeRecord.InsertContext(pCtx, o.model)
+}
+
+func (o *OpalEntity) SaveContext(pCtx context.Context) Result {
+	return o.activeRecord.SaveContext(pCtx, o.model)
+}
+
+func (o *OpalEntity) DeleteContext(pCtx context.Context) Result {
+	return o.activeRecord.DeleteContext(pCtx, o.model)
+}
+
 func (o *OpalEntity) Metadata() ModelMetadata {
 	return *o.metadata
 }
@@ -125,3 +168,30 @@ func (o *OpalEntity) Metadata() ModelMetadata {
 func (o *OpalEntity) String() string {
 	return fmt.Sprint(BindArgs(o.model)...)
 }
+
+func (o *OpalEntity) Snapshot() {
+	o.snapshot = modelValues(o.model)
+}
+
+// Gets the snapshot so a rolled back Transaction can put it back
+func (o *OpalEntity) snapshotValues() []driver.Value {
+	return o.snapshot
+}
+
+func (o *OpalEntity) restoreSnapshot(pSnapshot []driver.Value) {
+	o.snapshot = pSnapshot
+}
+
+func (o *OpalEntity) IsDirty() bool {
+	return len(o.DirtyFields()) > 0
+}
+
+func (o *OpalEntity) DirtyFields() (fields []string) {
+	columns := o.metadata.orderedColumns()
+	for i, value := range modelValues(o.model) {
+		if o.snapshot == nil || !sameValue(value, o.snapshot[i]) {
+			fields = append(fields, columns[i].Identifier)
+		}
+	}
+	return
+}
@@ -0,0 +1,806 @@
+package opal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeDriver is a minimal in memory database/sql driver which
+// understands the subset of sql that opal generates. Each data
+// source name is a separate database which lives for the life
+// of the test binary.
+type fakeDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeDB
+}
+
+var testDriver = &fakeDriver{dbs: make(map[string]*fakeDB)}
+
+func init() {
+	sql.Register("opaltest", testDriver)
+}
+
+// Gets the named fake database creating it if required
+func (o *fakeDriver) db(pName string) *fakeDB {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	db, ok := o.dbs[pName]
+	if !ok {
+		db = &fakeDB{name: pName, tables: make(map[string]*fakeTable)}
+		o.dbs[pName] = db
+	}
+	return db
+}
+
+func (o *fakeDriver) Open(pName string) (driver.Conn, error) {
+	return &fakeConn{db: o.db(pName)}, nil
+}
+
+type fakeDB struct {
+	mu     sync.Mutex
+	name   string
+	tables map[string]*fakeTable
+
+	// Every statement executed in order
+	executed []string
+
+	// Every statement prepared in order, the number closed and
+	// the version of the schema statements are prepared against
+	prepared []string
+	closed   int
+	schema   int
+
+	// Fails the next commit rolling it back
+	failCommit error
+
+	// Fails the statements which begin with the prefix
+	failPrefix string
+
+	// The most bind args a statement takes as its Dialect says
+	maxBindArgs int
+}
+
+// Fails the statements which begin with the prefix until reset
+func (o *fakeDB) fail(pPrefix string) {
+	o.mu.Lock()
+	o.failPrefix = pPrefix
+	o.mu.Unlock()
+}
+
+// Changes the schema so the statements prepared before fail
+func (o *fakeDB) changeSchema() {
+	o.mu.Lock()
+	o.schema++
+	o.mu.Unlock()
+}
+
+// Gets the number of prepared statements which begin with the prefix
+func (o *fakeDB) preparedCount(pPrefix string) (n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.prepared {
+		if strings.HasPrefix(s, pPrefix) {
+			n++
+		}
+	}
+	return
+}
+
+// Gets the number of executed statements which begin with the prefix
+func (o *fakeDB) count(pPrefix string) (n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.executed {
+		if strings.HasPrefix(s, pPrefix) {
+			n++
+		}
+	}
+	return
+}
+
+// Forgets all executed and prepared statements
+func (o *fakeDB) reset() {
+	o.mu.Lock()
+	o.executed = nil
+	o.prepared = nil
+	o.closed = 0
+	o.failPrefix = ""
+	o.mu.Unlock()
+}
+
+type fakeTable struct {
+	db       *fakeDB
+	columns  []string
+	defaults []string
+	keys     []string
+
+	// The single INTEGER PRIMARY KEY column if any
+	rowId  string
+	nextId int64
+	rows   [][]driver.Value
+}
+
+func (o *fakeTable) index(pColumn string) int {
+	if i := strings.LastIndex(pColumn, "."); i >= 0 {
+		pColumn = pColumn[i+1:]
+	}
+	for i, column := range o.columns {
+		if column == pColumn {
+			return i
+		}
+	}
+	return -1
+}
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+type fakeTx struct {
+	conn *fakeConn
+
+	// Reverses the writes made during the transaction
+	undo []func()
+
+	// The length of undo when each savepoint was set
+	savepoints map[string]int
+}
+
+func (o *fakeTx) Commit() error {
+	o.conn.db.mu.Lock()
+	if err := o.conn.db.failCommit; err != nil {
+		o.conn.db.failCommit = nil
+		o.conn.db.mu.Unlock()
+		o.Rollback()
+		return err
+	}
+	o.conn.db.executed = append(o.conn.db.executed, "COMMIT")
+	o.conn.db.mu.Unlock()
+	o.conn.tx = nil
+	return nil
+}
+
+func (o *fakeTx) Rollback() error {
+	o.conn.db.mu.Lock()
+	for i := len(o.undo) - 1; i >= 0; i-- {
+		o.undo[i]()
+	}
+	o.conn.db.executed = append(o.conn.db.executed, "ROLLBACK")
+	o.conn.db.mu.Unlock()
+	o.conn.tx = nil
+	return nil
+}
+
+func (o *fakeConn) Prepare(pQuery string) (driver.Stmt, error) {
+	if _, err := parseFake(pQuery); err != nil {
+		return nil, err
+	}
+	o.db.mu.Lock()
+	defer o.db.mu.Unlock()
+	o.db.prepared = append(o.db.prepared, pQuery)
+	return &fakeStmt{conn: o, query: pQuery, schema: o.db.schema}, nil
+}
+
+func (o *fakeConn) Close() error {
+	return nil
+}
+
+func (o *fakeConn) Begin() (driver.Tx, error) {
+	return o.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// Records the isolation level and read only option of the transaction
+func (o *fakeConn) BeginTx(pCtx context.Context, pOptions driver.TxOptions) (driver.Tx, error) {
+	if o.tx != nil {
+		return nil, errors.New("fakedb: transaction already active")
+	}
+	begin := "BEGIN"
+	if pOptions.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		begin += " " + sql.IsolationLevel(pOptions.Isolation).String()
+	}
+	if pOptions.ReadOnly {
+		begin += " READ ONLY"
+	}
+	o.db.mu.Lock()
+	o.db.executed = append(o.db.executed, begin)
+	o.db.mu.Unlock()
+	o.tx = &fakeTx{conn: o}
+	return o.tx, nil
+}
+
+type fakeStmt struct {
+	conn   *fakeConn
+	query  string
+	schema int
+}
+
+func (o *fakeStmt) Close() error {
+	o.conn.db.mu.Lock()
+	o.conn.db.closed++
+	o.conn.db.mu.Unlock()
+	return nil
+}
+
+// Fails a statement prepared before the schema changed
+func (o *fakeStmt) stale() error {
+	o.conn.db.mu.Lock()
+	defer o.conn.db.mu.Unlock()
+	if o.schema != o.conn.db.schema {
+		return errors.New("fakedb: database schema has changed")
+	}
+	return nil
+}
+
+func (o *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (o *fakeStmt) Exec(pArgs []driver.Value) (driver.Result, error) {
+	if err := o.stale(); err != nil {
+		return nil, err
+	}
+	result, _, err := o.conn.run(o.query, pArgs)
+	return result, err
+}
+
+func (o *fakeStmt) Query(pArgs []driver.Value) (driver.Rows, error) {
+	if err := o.stale(); err != nil {
+		return nil, err
+	}
+	_, rows, err := o.conn.run(o.query, pArgs)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &fakeRows{}
+	}
+	return rows, nil
+}
+
+type fakeResult struct {
+	id       int64
+	affected int64
+}
+
+func (o fakeResult) LastInsertId() (int64, error) {
+	return o.id, nil
+}
+
+func (o fakeResult) RowsAffected() (int64, error) {
+	return o.affected, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	i       int
+}
+
+func (o *fakeRows) Columns() []string {
+	return o.columns
+}
+
+func (o *fakeRows) Close() error {
+	return nil
+}
+
+func (o *fakeRows) Next(pDest []driver.Value) error {
+	if o.i >= len(o.rows) {
+		return io.EOF
+	}
+	copy(pDest, o.rows[o.i])
+	o.i++
+	return nil
+}
+
+// ******************************************** Parsing
+
+var (
+	fakeCreate  = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)\s*\((.*)\)$`)
+	fakeIndex   = regexp.MustCompile(`^CREATE (UNIQUE )?INDEX IF NOT EXISTS (\w+) ON (\w+)\s*\((.*)\)$`)
+	fakeInsert  = regexp.MustCompile(`^INSERT INTO (\w+)\s*\((.*?)\) VALUES \((.*?)\)(?: RETURNING (.*))?$`)
+	fakeSelect  = regexp.MustCompile(`^SELECT (.*?) FROM (\w+)(?: WHERE (.*))?$`)
+	fakeUpdate  = regexp.MustCompile(`^UPDATE (\w+) SET (.*?)(?: WHERE (.*?))?(?: RETURNING (.*))?$`)
+	fakeDelete  = regexp.MustCompile(`^DELETE FROM (\w+)(?: WHERE (.*))?$`)
+	fakeSave    = regexp.MustCompile(`^(SAVEPOINT|ROLLBACK TO SAVEPOINT|RELEASE SAVEPOINT) (\w+)$`)
+	fakeIn      = regexp.MustCompile(`^([\w.]+) IN \((.*)\)$`)
+	fakeInQuery = regexp.MustCompile(`^([\w.]+) IN \((SELECT .*)\)$`)
+	fakeDefault = regexp.MustCompile(` DEFAULT (\S+)`)
+)
+
+type fakeQuery struct {
+	kind    string
+	table   string
+	columns []string
+	// Column definitions and constraints of a create
+	defs      []string
+	set       []string
+	where     []string
+	returning []string
+}
+
+func parseFake(pQuery string) (*fakeQuery, error) {
+	q := new(fakeQuery)
+	if m := fakeCreate.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.defs = "CREATE", m[1], splitFake(m[2])
+	} else if m := fakeIndex.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.columns = "INDEX", m[3], splitFake(m[4])
+	} else if m := fakeInsert.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.columns, q.returning = "INSERT", m[1], splitFake(m[2]), splitFake(m[4])
+	} else if m := fakeSelect.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.columns, q.where = "SELECT", m[2], splitFake(m[1]), splitWhere(m[3])
+	} else if m := fakeUpdate.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.set, q.where, q.returning = "UPDATE", m[1], splitFake(m[2]), splitWhere(m[3]), splitFake(m[4])
+	} else if m := fakeDelete.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table, q.where = "DELETE", m[1], splitWhere(m[2])
+	} else if m := fakeSave.FindStringSubmatch(pQuery); m != nil {
+		q.kind, q.table = m[1], m[2]
+	} else {
+		return nil, fmt.Errorf("fakedb: unsupported sql: %s", pQuery)
+	}
+	return q, nil
+}
+
+// Splits a list on the commas which are not within parentheses
+func splitFake(pList string) (list []string) {
+	depth, last := 0, 0
+	for i, r := range pList {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				list = append(list, strings.TrimSpace(pList[last:i]))
+				last = i + 1
+			}
+		}
+	}
+	if s := strings.TrimSpace(pList[last:]); s != "" {
+		list = append(list, s)
+	}
+	return
+}
+
+func splitWhere(pWhere string) []string {
+	if pWhere == "" {
+		return nil
+	}
+	return strings.Split(pWhere, " AND ")
+}
+
+// ******************************************** Execution
+
+// Runs a query against the database binding the args in order
+func (o *fakeConn) run(pQuery string, pArgs []driver.Value) (driver.Result, driver.Rows, error) {
+	q, err := parseFake(pQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := placeholders(pQuery)
+	if n != len(pArgs) {
+		return nil, nil, fmt.Errorf("fakedb: %d args for %d placeholders: %s", len(pArgs), n, pQuery)
+	}
+	db := o.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.maxBindArgs > 0 && n > db.maxBindArgs {
+		return nil, nil, fmt.Errorf("fakedb: too many SQL variables: %s", pQuery)
+	}
+	db.executed = append(db.executed, pQuery)
+	if db.failPrefix != "" && strings.HasPrefix(pQuery, db.failPrefix) {
+		return nil, nil, fmt.Errorf("fakedb: failed: %s", pQuery)
+	}
+
+	if q.kind == "CREATE" {
+		db.create(q)
+		return fakeResult{}, nil, nil
+	}
+	if strings.HasSuffix(q.kind, "SAVEPOINT") {
+		return fakeResult{}, nil, o.savepoint(q.kind, q.table)
+	}
+	t, ok := db.tables[q.table]
+	if !ok {
+		return nil, nil, fmt.Errorf("fakedb: no such table: %s", q.table)
+	}
+	args := &fakeArgs{args: pArgs}
+	switch q.kind {
+	case "INDEX":
+		return fakeResult{}, nil, nil
+	case "INSERT":
+		return o.insert(t, q, args)
+	case "SELECT":
+		rows := new(fakeRows)
+		var indexes []int
+		for _, column := range q.columns {
+			if column == "*" {
+				for i := range t.columns {
+					indexes = append(indexes, i)
+				}
+				rows.columns = append(rows.columns, t.columns...)
+				continue
+			}
+			i := t.index(column)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("fakedb: no such column: %s", column)
+			}
+			indexes = append(indexes, i)
+			rows.columns = append(rows.columns, column)
+		}
+		matches, err := t.match(q.where, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range matches {
+			values := make([]driver.Value, len(indexes))
+			for j, i := range indexes {
+				values[j] = t.rows[row][i]
+			}
+			rows.rows = append(rows.rows, values)
+		}
+		return nil, rows, nil
+	case "UPDATE":
+		type assign struct {
+			column int
+			value  driver.Value
+		}
+		var assigns []assign
+		for _, set := range q.set {
+			parts := strings.SplitN(set, " = ", 2)
+			i := t.index(parts[0])
+			if i < 0 || len(parts) != 2 {
+				return nil, nil, fmt.Errorf("fakedb: bad set: %s", set)
+			}
+			value, err := args.value(parts[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			assigns = append(assigns, assign{i, value})
+		}
+		matches, err := t.match(q.where, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		var updated [][]driver.Value
+		for _, row := range matches {
+			old := append([]driver.Value(nil), t.rows[row]...)
+			for _, a := range assigns {
+				t.rows[row][a.column] = a.value
+			}
+			updated = append(updated, t.rows[row])
+			o.record(func() { copy(t.rows[row], old) })
+		}
+		return fakeResult{affected: int64(len(matches))}, t.returning(q.returning, updated...), nil
+	case "DELETE":
+		matches, err := t.match(q.where, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := len(matches) - 1; i >= 0; i-- {
+			row := matches[i]
+			old := t.rows[row]
+			t.rows = append(t.rows[:row], t.rows[row+1:]...)
+			o.record(func() {
+				t.rows = append(t.rows[:row], append([][]driver.Value{old}, t.rows[row:]...)...)
+			})
+		}
+		return fakeResult{affected: int64(len(matches))}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("fakedb: unsupported sql: %s", pQuery)
+}
+
+// Sets, rolls back to or releases a savepoint of the transaction
+func (o *fakeConn) savepoint(pKind, pName string) error {
+	if o.tx == nil {
+		return errors.New("fakedb: savepoint outside a transaction")
+	}
+	if pKind == "SAVEPOINT" {
+		if o.tx.savepoints == nil {
+			o.tx.savepoints = make(map[string]int)
+		}
+		o.tx.savepoints[pName] = len(o.tx.undo)
+		return nil
+	}
+	mark, ok := o.tx.savepoints[pName]
+	if !ok {
+		return fmt.Errorf("fakedb: no such savepoint: %s", pName)
+	}
+	if pKind == "ROLLBACK TO SAVEPOINT" {
+		for i := len(o.tx.undo) - 1; i >= mark; i-- {
+			o.tx.undo[i]()
+		}
+		o.tx.undo = o.tx.undo[:mark]
+	}
+	// A released savepoint's writes simply stay in the transaction
+	if pKind == "RELEASE SAVEPOINT" {
+		delete(o.tx.savepoints, pName)
+	}
+	return nil
+}
+
+// Counts the placeholders of a statement outside quoted strings
+func placeholders(pQuery string) (n int) {
+	quoted := false
+	for _, c := range pQuery {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+		}
+	}
+	return
+}
+
+// Records how to reverse a write when inside a transaction
+func (o *fakeConn) record(fUndo func()) {
+	if o.tx != nil {
+		o.tx.undo = append(o.tx.undo, fUndo)
+	}
+}
+
+func (o *fakeDB) create(q *fakeQuery) {
+	if _, ok := o.tables[q.table]; ok {
+		return
+	}
+	t := &fakeTable{db: o}
+	for _, def := range q.defs {
+		if strings.HasPrefix(def, "PRIMARY KEY") {
+			inner := def[strings.Index(def, "(")+1 : strings.LastIndex(def, ")")]
+			t.keys = splitFake(inner)
+			continue
+		}
+		if strings.HasPrefix(def, "FOREIGN KEY") || strings.HasPrefix(def, "UNIQUE") {
+			continue
+		}
+		name := strings.Fields(def)[0]
+		t.columns = append(t.columns, name)
+		var value string
+		if m := fakeDefault.FindStringSubmatch(def); m != nil {
+			value = m[1]
+		}
+		t.defaults = append(t.defaults, value)
+		if strings.Contains(def, "PRIMARY KEY") {
+			t.keys = []string{name}
+			if strings.Contains(def, "INTEGER") {
+				t.rowId = name
+			}
+		}
+	}
+	o.tables[q.table] = t
+}
+
+func (o *fakeConn) insert(t *fakeTable, q *fakeQuery, pArgs *fakeArgs) (driver.Result, driver.Rows, error) {
+	row := make([]driver.Value, len(t.columns))
+	for i, value := range t.defaults {
+		row[i] = fakeLiteral(value)
+	}
+	for _, column := range q.columns {
+		i := t.index(column)
+		if i < 0 {
+			return nil, nil, fmt.Errorf("fakedb: no such column: %s", column)
+		}
+		value, err := pArgs.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		row[i] = value
+	}
+	var id int64
+	if t.rowId != "" {
+		i := t.index(t.rowId)
+		if row[i] == nil {
+			t.nextId++
+			row[i] = t.nextId
+		} else if v, ok := row[i].(int64); ok && v > t.nextId {
+			t.nextId = v
+		}
+		id, _ = row[i].(int64)
+	}
+	for _, existing := range t.rows {
+		if len(t.keys) > 0 && t.sameKey(existing, row) {
+			return nil, nil, errors.New("fakedb: UNIQUE constraint failed")
+		}
+	}
+	t.rows = append(t.rows, row)
+	o.record(func() {
+		for i, existing := range t.rows {
+			if t.sameKey(existing, row) {
+				t.rows = append(t.rows[:i], t.rows[i+1:]...)
+				return
+			}
+		}
+	})
+	return fakeResult{id: id, affected: 1}, t.returning(q.returning, row), nil
+}
+
+// Gets the rows of a RETURNING clause or nil if there is none
+func (o *fakeTable) returning(pColumns []string, pRows ...[]driver.Value) driver.Rows {
+	if len(pColumns) == 0 {
+		return nil
+	}
+	rows := &fakeRows{columns: pColumns}
+	for _, row := range pRows {
+		values := make([]driver.Value, len(pColumns))
+		for i, column := range pColumns {
+			values[i] = row[o.index(column)]
+		}
+		rows.rows = append(rows.rows, values)
+	}
+	return rows
+}
+
+// Gets the value of a literal default expression
+func fakeLiteral(pExpr string) driver.Value {
+	switch {
+	case pExpr == "" || pExpr == "NULL":
+		return nil
+	case pExpr == "CURRENT_TIMESTAMP":
+		return time.Now()
+	case strings.HasPrefix(pExpr, "'"):
+		return strings.Trim(pExpr, "'")
+	}
+	if i, err := strconv.ParseInt(pExpr, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(pExpr, 64); err == nil {
+		return f
+	}
+	return pExpr
+}
+
+func (o *fakeTable) sameKey(a, b []driver.Value) bool {
+	for _, key := range o.keys {
+		i := o.index(key)
+		if !fakeEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Gets the positions of the rows which match every condition
+func (o *fakeTable) match(pWhere []string, pArgs *fakeArgs) ([]int, error) {
+	type cond struct {
+		column int
+		op     string
+		values []driver.Value
+	}
+	var conds []cond
+	for _, where := range pWhere {
+		var c cond
+		switch {
+		case strings.HasSuffix(where, " IS NULL"):
+			c.column, c.op = o.index(strings.TrimSuffix(where, " IS NULL")), "null"
+		case strings.HasSuffix(where, " IS NOT NULL"):
+			c.column, c.op = o.index(strings.TrimSuffix(where, " IS NOT NULL")), "notnull"
+		case fakeInQuery.MatchString(where):
+			m := fakeInQuery.FindStringSubmatch(where)
+			values, err := o.db.subquery(m[2], pArgs)
+			if err != nil {
+				return nil, err
+			}
+			c.column, c.op, c.values = o.index(m[1]), "in", values
+		case fakeIn.MatchString(where):
+			m := fakeIn.FindStringSubmatch(where)
+			c.column, c.op = o.index(m[1]), "in"
+			for _, bind := range splitFake(m[2]) {
+				value, err := pArgs.value(bind)
+				if err != nil {
+					return nil, err
+				}
+				c.values = append(c.values, value)
+			}
+		default:
+			parts := strings.SplitN(where, " = ", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("fakedb: bad condition: %s", where)
+			}
+			value, err := pArgs.value(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			c.column, c.op, c.values = o.index(parts[0]), "in", []driver.Value{value}
+		}
+		if c.column < 0 {
+			return nil, fmt.Errorf("fakedb: bad condition: %s", where)
+		}
+		conds = append(conds, c)
+	}
+	var matches []int
+rows:
+	for i, row := range o.rows {
+		for _, c := range conds {
+			value := row[c.column]
+			switch c.op {
+			case "null":
+				if value != nil {
+					continue rows
+				}
+			case "notnull":
+				if value == nil {
+					continue rows
+				}
+			case "in":
+				found := false
+				for _, v := range c.values {
+					if fakeEqual(value, v) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					continue rows
+				}
+			}
+		}
+		matches = append(matches, i)
+	}
+	return matches, nil
+}
+
+// Gets the values of a subquery selecting a single column
+func (o *fakeDB) subquery(pQuery string, pArgs *fakeArgs) ([]driver.Value, error) {
+	q, err := parseFake(pQuery)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := o.tables[q.table]
+	if !ok || q.kind != "SELECT" || len(q.columns) != 1 || t.index(q.columns[0]) < 0 {
+		return nil, fmt.Errorf("fakedb: unsupported subquery: %s", pQuery)
+	}
+	matches, err := t.match(q.where, pArgs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]driver.Value, len(matches))
+	for i, row := range matches {
+		values[i] = t.rows[row][t.index(q.columns[0])]
+	}
+	return values, nil
+}
+
+type fakeArgs struct {
+	args []driver.Value
+	i    int
+}
+
+func (o *fakeArgs) next() (driver.Value, error) {
+	if o.i >= len(o.args) {
+		return nil, errors.New("fakedb: not enough args")
+	}
+	o.i++
+	return o.args[o.i-1], nil
+}
+
+// Gets the value for a bind or literal expression
+func (o *fakeArgs) value(pExpr string) (driver.Value, error) {
+	if pExpr == "?" {
+		return o.next()
+	}
+	return nil, fmt.Errorf("fakedb: unsupported expression: %s", pExpr)
+}
+
+func fakeEqual(a, b driver.Value) bool {
+	switch v := a.(type) {
+	case []byte:
+		w, ok := b.([]byte)
+		return ok && bytes.Equal(v, w)
+	case time.Time:
+		w, ok := b.(time.Time)
+		return ok && v.Equal(w)
+	}
+	return a == b
+}
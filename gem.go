@@ -1,10 +1,14 @@
 package opal
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log"
-	"sync"
+	"reflect"
+	"strings"
 )
 
 // Go entity manager
@@ -13,19 +17,25 @@ type Gem struct {
 	*sql.DB
 	Dialect
 
-	// txMu locks access to daoMu during a transaction
-	txMu sync.Mutex
+	// The statements prepared on each connection pool
+	stmts *stmtCache
 
-	// Will usually be nil
-	tx                   *Txn
-	txPreparedStatements map[*sql.Stmt]*sql.Stmt
+	// Read replicas of the DB and the route which picks
+	// the replica of each read
+	replicas []*sql.DB
+	route    ReplicaRoute
 
 	dao               *ModelIDAO // TODO change to embedded dao?
 	modelNames        []ModelName
 	allModelsMetadata map[ModelName]ModelMetadata
-	allModelsEntity   map[ModelName]*Entity
 
-	funcCreateDomainEntity func(pModelName ModelName) Entity
+	// The Gem's own default Entity and DAO of each Model and
+	// the ModelName of each Model type
+	allModelsEntity map[ModelName]Entity
+	allModelsDAO    map[ModelName]ModelDAO
+	modelTypes      map[reflect.Type]ModelName
+
+	funcCreateDomainEntity func(pGem *Gem, pModelName ModelName) Entity
 
 	// TODO make it work without INIT add reflection based
 	// so Entities can be made on the fly
@@ -34,16 +44,83 @@ type Gem struct {
 
 // Gets a copy of the metadata associated with the Model
 // which is identified by its ModelName
-func (o Gem) Metadata(pModelName ModelName) ModelMetadata {
+func (o *Gem) Metadata(pModelName ModelName) ModelMetadata {
 	return o.allModelsMetadata[pModelName]
 }
 
+// DAO gets the Gem's domain specific DAO of the Model
+func (o *Gem) DAO(pModelName ModelName) ModelDAO {
+	dao, ok := o.allModelsDAO[pModelName]
+	if !ok {
+		panic(fmt.Sprintf("Opal.Gem: %s is not a Model of the Gem", pModelName))
+	}
+	return dao
+}
+
+// Bind sets the Entity of a Model made outside the Gem so its
+// ActiveRecord calls run through the Gem. The Models the Gem
+// finds are bound to it already.
+func (o *Gem) Bind(pModel Model) Model {
+	name := o.modelName(pModel)
+	entityField(pModel).Set(reflect.ValueOf(o.allModelsEntity[name].New(pModel)))
+	return pModel
+}
+
+// Gets the ModelName of a Model whether it is bound or not
+func (o *Gem) modelName(pModel Model) ModelName {
+	name, ok := o.modelTypes[reflect.TypeOf(pModel)]
+	if !ok {
+		panic(fmt.Sprintf("Opal.Gem: %T is not a Model of the Gem", pModel))
+	}
+	return name
+}
+
+// Binds the Model to the Gem when it has no Entity
+func (o *Gem) bound(pModel Model) Model {
+	if field := entityField(pModel); field.IsValid() && field.IsNil() {
+		o.Bind(pModel)
+	}
+	return pModel
+}
+
+// Gets the embedded Entity field of a Model
+func entityField(pModel Model) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(pModel))
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return v.FieldByName("Entity")
+}
+
+// Create a Sql Builder for the Model identified by its ModelName
+func (o *Gem) sqlBuilder(pModelName ModelName) *SqlBuilder {
+	meta := o.allModelsMetadata[pModelName]
+	builder := new(SqlBuilder)
+	builder.ModelMetadata = &meta
+	builder.Dialect = o.Dialect
+	return builder
+}
+
 // Runs a standard Db query which expects a slice of Models as a result,
 // Will take any Sql interface and the ModelName to identify Model
-func (o Gem) Query(pModelName ModelName, pSql Sql) ([]Model, error) {
+func (o *Gem) Query(pModelName ModelName, pSql Sql, pArgs ...interface{}) ([]Model, error) {
+	return o.QueryContext(context.Background(), pModelName, pSql, pArgs...)
+}
+
+// Runs a query as Query does drawing the values of the Model's
+// scopes from the context when the Sql came from a SqlBuilder
+func (o *Gem) QueryContext(pCtx context.Context, pModelName ModelName, pSql Sql, pArgs ...interface{}) ([]Model, error) {
+	if scoped, ok := pSql.(*scopedSql); ok {
+		args, err := scopedArgs(pCtx, pModelName, scoped.scopes, pArgs)
+		if err != nil {
+			log.Print(err)
+			return nil, err
+		}
+		pArgs = args
+	}
 	// Do query and convert results to Models
 	// TODO assert right model
-	rows, err := o.DB.Query(pSql.String())
+	rows, err := o.query(pCtx, pSql.String(), pArgs...)
 	if err != nil {
 		log.Print(err)
 		return nil, err
@@ -53,30 +130,141 @@ func (o Gem) Query(pModelName ModelName, pSql Sql) ([]Model, error) {
 	for rows.Next() {
 		model, args := o.Metadata(pModelName).ScanInto()
 		rows.Scan(args...)
+		o.Bind(model).Snapshot()
 		models = append(models, model)
 	}
 	return models, nil
 }
 
+// Runs a query inside the transaction the context is bound to
+// if there is one otherwise on the pool the read is routed to
+func (o *Gem) query(pCtx context.Context, pSql string, pArgs ...interface{}) (*sql.Rows, error) {
+	if tx := txFromContext(pCtx); tx != nil {
+		return tx.QueryContext(pCtx, pSql, pArgs...)
+	}
+	return o.reader(pCtx).QueryContext(pCtx, pSql, pArgs...)
+}
+
 // Runs a standard Db query which expects a Model as a result,
 // Will take any Sql interface and the ModelName to identify Model
 // TODO investigate do not support keyword as identifiers it's easier
-func (o Gem) QueryRow(pModelName ModelName, pSql Sql, pArgs ...interface{}) Model {
+func (o *Gem) QueryRow(pModelName ModelName, pSql Sql, pArgs ...interface{}) Model {
+	return o.QueryRowContext(context.Background(), pModelName, pSql, pArgs...)
+}
+
+// Runs a query as QueryRow does under the context
+func (o *Gem) QueryRowContext(pCtx context.Context, pModelName ModelName, pSql Sql, pArgs ...interface{}) Model {
+	if scoped, ok := pSql.(*scopedSql); ok {
+		args, err := scopedArgs(pCtx, pModelName, scoped.scopes, pArgs)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		pArgs = args
+	}
 	// Do query and convert results to Models
-	row := o.DB.QueryRow(pSql.String(), pArgs...)
+	var row *sql.Row
+	if tx := txFromContext(pCtx); tx != nil {
+		row = tx.QueryRowContext(pCtx, pSql.String(), pArgs...)
+	} else {
+		row = o.reader(pCtx).QueryRowContext(pCtx, pSql.String(), pArgs...)
+	}
 	model, args := o.Metadata(pModelName).ScanInto()
 	err := row.Scan(args...)
 	if err != nil {
 		//TODO determine how errors should be handled
 		return nil
 	}
+	o.Bind(model).Snapshot()
 	return model
 }
 
+// Names the update of only the fields of a Model. The name
+// holds the fields so its sql is built from it when prepared
+// and only the bounded statements are kept.
+func partialUpdate(pFields []string) string {
+	return update + "(" + strings.Join(pFields, ", ") + ")"
+}
+
+// Builds the sql of an ad-hoc statement from its name
+func (o *Gem) adHocSql(pModelName ModelName, pNamedStmt string) (string, bool) {
+	fields := strings.TrimPrefix(pNamedStmt, update+"(")
+	if fields == pNamedStmt || !strings.HasSuffix(fields, ")") {
+		return "", false
+	}
+	meta := o.allModelsMetadata[pModelName]
+	builder := o.sqlBuilder(pModelName).UpdateFields(strings.Split(strings.TrimSuffix(fields, ")"), ", ")...).WherePk().WhereVersion()
+	if meta.returning && len(meta.generatedColumns()) > 0 {
+		builder.ReturningGenerated()
+	}
+	return builder.Sql().String(), true
+}
+
+// Updates only the columns of the Model which changed since its
+// snapshot skipping the update when none did. A Model without a
+// snapshot has all its columns updated.
+func (o *Gem) merge(pExecor Execor, pModel Model) Result {
+	journalModel(pExecor, pModel)
+	meta := o.allModelsMetadata[pModel.ModelName()]
+	dirty := make(map[string]bool)
+	for _, field := range pModel.DirtyFields() {
+		dirty[field] = true
+	}
+	fields := dirtyUpdate(meta, dirty)
+	if len(fields) == 0 {
+		return Result{Result: returnedResult{pModel, 0}}
+	}
+	// Every update moves the version and update time on
+	for _, column := range meta.updateColumns() {
+		if column.Version || column.UpdatedAt {
+			dirty[column.Identifier] = true
+		}
+	}
+	fields = dirtyUpdate(meta, dirty)
+	if len(fields) == len(meta.updateColumns()) {
+		return merge(pExecor, pModel)
+	}
+	stamp(pModel, meta, false)
+	name := partialUpdate(fields)
+	fArgs := func(pModel Model) []interface{} {
+		args := filterArgs(pModel.Parameters(), meta.NonKeys(), func(pColumn Column) bool {
+			return pColumn.Updatable && dirty[pColumn.Identifier]
+		})
+		return append(args, pModel.Keys()...)
+	}
+	result := exec(pExecor, pModel, name, fArgs, updateHooks, updateStmt)
+	if result.Error == nil {
+		pModel.Snapshot()
+	}
+	return result
+}
+
+// Gets the updatable fields which are dirty
+func dirtyUpdate(pMeta ModelMetadata, pDirty map[string]bool) (fields []string) {
+	for _, column := range pMeta.updateColumns() {
+		if pDirty[column.Identifier] {
+			fields = append(fields, column.Identifier)
+		}
+	}
+	return
+}
+
 // TODO determine requirement error wrapping?
-func (o Gem) Exec(pSql Sql, pArgs ...interface{}) (sql.Result, error) {
+func (o *Gem) Exec(pSql Sql, pArgs ...interface{}) (sql.Result, error) {
+	return o.ExecContext(context.Background(), pSql, pArgs...)
+}
+
+// Runs an execution as Exec does under the context and inside
+// the transaction it is bound to if there is one
+func (o *Gem) ExecContext(pCtx context.Context, pSql Sql, pArgs ...interface{}) (sql.Result, error) {
 	// Do execution expect a result
-	result, err := o.DB.Exec(pSql.String(), pArgs...)
+	var result sql.Result
+	var err error
+	if tx := txFromContext(pCtx); tx != nil {
+		result, err = tx.ExecContext(pCtx, pSql.String(), pArgs...)
+	} else {
+		result, err = o.DB.ExecContext(pCtx, pSql.String(), pArgs...)
+	}
 	if err != nil {
 		log.Print(err)
 		return nil, err
@@ -84,76 +272,329 @@ func (o Gem) Exec(pSql Sql, pArgs ...interface{}) (sql.Result, error) {
 	return result, nil
 }
 
+// Close closes the statements the Gem prepared. The DB and replicas
+// were opened by the caller, and may be shared, so are left open.
+// The Gem cannot be used after.
+func (o *Gem) Close() error {
+	return o.stmts.close()
+}
+
 // ******************************************** NOT DEPENDENT ON GEM
 
-// calls the model exec method with delete args and hooks
+// calls the model exec method with delete args and hooks.
+// Models with a DeletedAt column are soft deleted.
 func remove(pExecor Execor, pModel Model) Result {
-	return exec(pExecor, pModel, delete, deleteArgs, deleteHooks)
+	journalModel(pExecor, pModel)
+	meta := pModel.Metadata()
+	column, ok := meta.deletedColumn()
+	if !ok {
+		return hardRemove(pExecor, pModel)
+	}
+	held := fieldValue(pModel, meta, column.Identifier)
+	now := meta.now()
+	setField(pModel, meta, column.Identifier, now)
+	fArgs := func(pModel Model) []interface{} {
+		return append([]interface{}{now}, deleteArgs(pModel)...)
+	}
+	result := exec(pExecor, pModel, softDelete, fArgs, deleteHooks, execStmt)
+	if result.Error != nil {
+		setField(pModel, meta, column.Identifier, held)
+		return result
+	}
+	pModel.Snapshot()
+	return result
+}
+
+// calls the model exec method with delete args and hooks
+// removing the row even when the Model is soft deleted
+func hardRemove(pExecor Execor, pModel Model) Result {
+	return exec(pExecor, pModel, hardDelete, deleteArgs, deleteHooks, execStmt)
 }
 
 // calls the model exec method with persist args and hooks
 func persist(pExecor Execor, pModel Model, pArgs ...interface{}) Result {
+	journalModel(pExecor, pModel)
 	fArgs := insertArgs
 	if len(pArgs) > 0 {
 		fArgs = func(Model) []interface{} {
 			return pArgs
 		}
 	}
-	result := exec(pExecor, pModel, insert, fArgs, insertHooks)
-	if result.Error != nil {
-		return result
+	meta := pModel.Metadata()
+	if version, ok := meta.versionColumn(); ok && fieldValue(pModel, meta, version.Identifier) == nil {
+		setField(pModel, meta, version.Identifier, int64(1))
 	}
-	// TODO dialect for Id
-	var id int64
-	if id, result.Error = result.LastInsertId(); result.Error == nil {
-		v, ok := pModel.Keys()[0].(*AutoIncrement)
-		if ok {
-			v.A(id)
-		}
-		v2, ok := pModel.Keys()[0].(*Int64)
-		if ok {
-			v2.A(id)
-		}
+	stamp(pModel, meta, true)
+	if err := scopeInsert(pExecor.ExecorContext(), pModel); err != nil {
+		return Result{Error: err}
+	}
+	result := exec(pExecor, pModel, insert, fArgs, insertHooks, insertStmt)
+	if result.Error == nil {
+		pModel.Snapshot()
 	}
 	return result
 } // TODO metadata API and interface - check security
 
+// Stamps the timestamp columns of a Model before it is written.
+// A CreatedAt column is only stamped on insert and when empty.
+func stamp(pModel Model, pMeta ModelMetadata, pInsert bool) {
+	var now driver.Value
+	for _, column := range pMeta.NonKeys() {
+		if !column.UpdatedAt && !(column.CreatedAt && pInsert) {
+			continue
+		}
+		if column.CreatedAt && fieldValue(pModel, pMeta, column.Identifier) != nil {
+			continue
+		}
+		if now == nil {
+			now = pMeta.now()
+		}
+		setField(pModel, pMeta, column.Identifier, now)
+	}
+}
+
+// Assigns the last insert id to a Model with a single integer key.
+// Compound keys are always supplied by the user so are left untouched.
+func assignInsertId(pModel Model, pId int64) {
+	keys := pModel.Keys()
+	if len(keys) != 1 {
+		return
+	}
+	switch key := keys[0].(type) {
+	case *AutoIncrement:
+		key.A(pId)
+	case *Int64:
+		key.A(pId)
+	}
+}
+
 // calls the model exec method with update args and hooks
 func merge(pExecor Execor, pModel Model) Result {
-	return exec(pExecor, pModel, update, updateArgs, updateHooks)
+	journalModel(pExecor, pModel)
+	stamp(pModel, pModel.Metadata(), false)
+	result := exec(pExecor, pModel, update, updateArgs, updateHooks, updateStmt)
+	if result.Error == nil {
+		pModel.Snapshot()
+	}
+	return result
 }
 
+// StmtRunner runs a Model's named statement with its args
+type StmtRunner func(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}) (sql.Result, error)
+
 // exec handles the execution of basic Models with no joins
-func exec(pExecor Execor, pModel Model, pNamedStmt string, fArgs ModelArgs, fModelHooks func(Model) (ModelHook, ModelHook)) Result {
-	fPre, fPost := fModelHooks(pModel)
+func exec(pExecor Execor, pModel Model, pNamedStmt string, fArgs ModelArgs, fModelHooks func(context.Context, Model) (ModelHook, ModelHook, func()), fRun StmtRunner) Result {
+	fPre, fPost, fAfterCommit := fModelHooks(pExecor.ExecorContext(), pModel)
 	if fPre != nil {
 		err := fPre()
 		if err != nil {
-			return Result{nil, err}
+			return Result{Error: err}
 		}
 	}
-	// TODO delete bug
-	if pNamedStmt == delete {
-		fmt.Println(pModel.ModelName(), pNamedStmt, "Delete here")
-		fmt.Printf("%#v", pExecor.ExecorStmt(pModel.ModelName(), pNamedStmt))
-	}
-	result, err := pExecor.ExecorStmt(pModel.ModelName(), pNamedStmt).Exec(fArgs(pModel)...)
+	result, err := fRun(pExecor, pModel, pNamedStmt, fArgs(pModel))
 	if err != nil {
-		return Result{result, err}
+		return Result{Result: result, Error: err}
 	}
 	if fPost != nil {
 		err := fPost()
 		if err != nil {
-			return Result{result, err}
+			return Result{Result: result, Error: err}
+		}
+	}
+	if fAfterCommit != nil {
+		// Outside a transaction the write is already committed
+		if tx := txFromContext(pExecor.ExecorContext()); tx != nil {
+			tx.AfterCommit(fAfterCommit)
+		} else {
+			fAfterCommit()
+		}
+	}
+	return Result{Result: result}
+}
+
+// Runs a statement which returns no rows
+func execStmt(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}) (sql.Result, error) {
+	args, err := stmtArgs(pExecor, pModel, pNamedStmt, pArgs)
+	if err != nil {
+		return nil, err
+	}
+	var result sql.Result
+	err = pExecor.ExecorStmt(pModel.ModelName(), pNamedStmt, func(pStmt *sql.Stmt) (err error) {
+		result, err = pStmt.ExecContext(pExecor.ExecorContext(), args...)
+		return
+	})
+	return result, err
+}
+
+// Appends the values of the Model's scopes to the args of all
+// its statements but the insert
+func stmtArgs(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}) ([]interface{}, error) {
+	if pNamedStmt == insert {
+		return pArgs, nil
+	}
+	return scopedArgs(pExecor.ExecorContext(), pModel.ModelName(), pModel.Metadata().scopes, pArgs)
+}
+
+// Runs the insert statement and reads back the key and any
+// columns which the database generated
+func insertStmt(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}) (sql.Result, error) {
+	meta := pModel.Metadata()
+	if meta.returning {
+		return returnStmt(pExecor, pModel, pNamedStmt, pArgs, meta.insertReturnColumns())
+	}
+	result, err := execStmt(pExecor, pModel, pNamedStmt, pArgs)
+	if err != nil {
+		return result, err
+	}
+	// TODO dialect for Id
+	if id, err := result.LastInsertId(); err == nil {
+		assignInsertId(pModel, id)
+	}
+	if len(meta.generatedColumns()) > 0 {
+		return result, readBack(pExecor, pModel)
+	}
+	return result, nil
+}
+
+// Runs the update statement and reads back any columns
+// which the database generated. A versioned Model binds its
+// next version and matches the row by the version it holds.
+func updateStmt(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}) (sql.Result, error) {
+	meta := pModel.Metadata()
+	version, versioned := meta.versionColumn()
+	var held driver.Value
+	if versioned {
+		held = fieldValue(pModel, meta, version.Identifier)
+		setField(pModel, meta, version.Identifier, nextVersion(held))
+		pArgs = append(pArgs, held)
+	}
+	returned := meta.returning && len(meta.generatedColumns()) > 0
+	var result sql.Result
+	var err error
+	if returned {
+		result, err = returnStmt(pExecor, pModel, pNamedStmt, pArgs, meta.generatedColumns())
+	} else {
+		result, err = execStmt(pExecor, pModel, pNamedStmt, pArgs)
+	}
+	if err == nil && versioned {
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			err = &ErrStaleModel{pModel.ModelName(), keyValues(pModel)}
 		}
 	}
-	return Result{result, nil}
+	if err != nil {
+		if versioned {
+			setField(pModel, meta, version.Identifier, held)
+		}
+		return result, err
+	}
+	if returned || len(meta.generatedColumns()) == 0 {
+		return result, nil
+	}
+	return result, readBack(pExecor, pModel)
+}
+
+// Gets the version which follows the one held
+func nextVersion(pHeld driver.Value) int64 {
+	if held, ok := pHeld.(int64); ok {
+		return held + 1
+	}
+	return 1
+}
+
+// Gets the driver values of a Model's keys
+func keyValues(pModel Model) []interface{} {
+	keys := pModel.Keys()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i], _ = key.(driver.Valuer).Value()
+	}
+	return values
+}
+
+// ErrStaleModel is the error of an update to a versioned Model
+// whose row was changed or removed since the Model was read. The
+// Model keeps its changes and version so it can be found again
+// and the changes reapplied.
+type ErrStaleModel struct {
+	ModelName ModelName
+	Key       []interface{}
+}
+
+func (o *ErrStaleModel) Error() string {
+	return fmt.Sprintf("Opal: %s %v is stale", o.ModelName, o.Key)
+}
+
+// Runs a statement with a RETURNING clause scanning the
+// returned columns into the Model
+func returnStmt(pExecor Execor, pModel Model, pNamedStmt string, pArgs []interface{}, pColumns []Column) (sql.Result, error) {
+	meta := pModel.Metadata()
+	returned := make(map[string]bool, len(pColumns))
+	for _, column := range pColumns {
+		returned[column.Identifier] = true
+	}
+	dest := filterArgs(BindArgs(pModel), meta.orderedColumns(), func(pColumn Column) bool {
+		return returned[pColumn.Identifier]
+	})
+	args, err := stmtArgs(pExecor, pModel, pNamedStmt, pArgs)
+	if err != nil {
+		return nil, err
+	}
+	err = pExecor.ExecorStmt(pModel.ModelName(), pNamedStmt, func(pStmt *sql.Stmt) error {
+		return pStmt.QueryRowContext(pExecor.ExecorContext(), args...).Scan(dest...)
+	})
+	if err == sql.ErrNoRows {
+		return returnedResult{pModel, 0}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return returnedResult{pModel, 1}, nil
+}
+
+// Reads the Model's row back into it by its key
+func readBack(pExecor Execor, pModel Model) error {
+	stmt := pModel.Metadata().scopedStmt(find, withDeleted)
+	args, err := stmtArgs(pExecor, pModel, stmt, pModel.Keys())
+	if err != nil {
+		return err
+	}
+	return pExecor.ExecorStmt(pModel.ModelName(), stmt, func(pStmt *sql.Stmt) error {
+		return pStmt.QueryRowContext(pExecor.ExecorContext(), args...).Scan(BindArgs(pModel)...)
+	})
+}
+
+// returnedResult is the sql.Result of a statement which returned
+// its changes rather than a driver result
+type returnedResult struct {
+	model    Model
+	affected int64
+}
+
+// Gets the Model's key when it is a single integer
+func (o returnedResult) LastInsertId() (int64, error) {
+	if keys := o.model.Keys(); len(keys) == 1 {
+		switch key := keys[0].(type) {
+		case *AutoIncrement:
+			return key.Primitive(), nil
+		case *Int64:
+			return key.Primitive(), nil
+		}
+	}
+	return 0, errors.New("Opal.Result: the Model does not have a single integer key")
+}
+
+func (o returnedResult) RowsAffected() (int64, error) {
+	return o.affected, nil
 }
 
 type Execor interface {
-	// Retrieve the statement required for the database work
-	// TODO handle discons
-	ExecorStmt(pModel ModelName, pNamedStmt string) *sql.Stmt
+	// Runs the call with the statement required for the database
+	// work. The statement is prepared on first use and again when
+	// it goes stale.
+	ExecorStmt(pModel ModelName, pNamedStmt string, fRun func(*sql.Stmt) error) error
+
+	// Retrieve the context the values of scopes are drawn from
+	ExecorContext() context.Context
 }
 
 // Result is a wrapper around a sql.Result and any
@@ -162,6 +603,9 @@ type Execor interface {
 type Result struct {
 	sql.Result
 	Error error
+
+	// The number of times a Transaction ran its Action
+	Attempts int
 }
 
 func (o Result) String() string {
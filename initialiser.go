@@ -12,6 +12,8 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -45,6 +47,10 @@ type TemplateType struct {
 	Table      string
 	Keys       []KeyField
 	Columns    []TemplateField
+	BelongsTo  []AssociationField
+	HasOne     []AssociationField
+	HasMany    []AssociationField
+	ManyToMany []AssociationField
 }
 
 type KeyField struct {
@@ -64,16 +70,47 @@ type TemplateField struct {
 	Primitive string
 }
 
+// AssociationField describes a generated accessor for a Model
+// related to another Model through a foreign key
+type AssociationField struct {
+	// The accessor name e.g. Owner or Pets
+	Name string
+
+	// The singular name used to add to a HasMany e.g. Pet
+	Singular string
+
+	// The unexported field caching the related Model if any
+	Field string
+
+	// The foreign key field e.g. OwnerId
+	ForeignKey string
+
+	// The related Model, its ModelName and its DAO
+	Model      string
+	ImportName string
+	DAOName    string
+
+	// The key the foreign key references and its primitive
+	Key       string
+	Primitive string
+
+	// The JoinTable fields of a ManyToMany
+	Through string
+
+	// The writes which cascade to the related Models
+	Cascade string
+}
+
 // INIT will scan each supplied Model/Domain object
 // and generate the relevant Boilerplate code with which you can run
 // TODO doco
 func INIT(pBaseModel BaseModel) {
-	gatherTemplateData(pBaseModel.Models())
+	runTemplate(gatherTemplateData(pBaseModel.Models()))
 }
 
-// The system will run the code generation template
-// based on the received skeletal Models and generate the code
-func gatherTemplateData(pModels []Domain) {
+// The system will gather the data for the code generation
+// template based on the received skeletal Models
+func gatherTemplateData(pModels []Domain) ModelTemplate {
 	plate := ModelTemplate{}
 
 	// Header information
@@ -108,24 +145,20 @@ func gatherTemplateData(pModels []Domain) {
 		s := strings.Split(temp.Path, "/")
 		temp.Package = s[len(s)-1]
 
-		var keys map[string]bool = make(map[string]bool)
+		// The key field names in their declared order
+		var keys []string
 		var i int
 		if model.Field(i).Type.Implements(reflect.TypeOf((*Entity)(nil)).Elem()) && model.Field(i).Anonymous {
 			opalTags := ExtractOpalTags(model.Field(i).Tag)
 
 			// Derive table name or get specific
-			if opalTags.Get("Name") == "" {
-				temp.Table = fmt.Sprintf("Name: %q", inflect.Tableize(temp.Model))
-			} else {
-				temp.Table = string(opalTags)
-			}
+			temp.Table = tableTag(opalTags, temp.Model)
 			if opalTags.Get("Key") == "" {
 				//useDefaultKey = true
-				keys["Id"] = true
+				keys = []string{"Id"}
 			} else {
-				// TODO support compound keys
 				key, _ := strconv.Unquote(opalTags.Get("Key"))
-				keys[key] = true
+				keys = splitKeys(key)
 			}
 			i++
 		} else {
@@ -143,14 +176,15 @@ func gatherTemplateData(pModels []Domain) {
 		if typ.Name() == "Key" && field.Anonymous {
 			opalTags := ExtractOpalTags(field.Tag)
 
-			// TODO support compound keys // TODO check field type
-			if _, ok := model.FieldByName(string(opalTags)); ok {
-				keys[string(opalTags)] = true
-			} else {
-				panic("Opal.runTemplate: Key metatag error")
-			}
+			// TODO check field type
+			keys = splitKeys(string(opalTags))
 			i++
 		}
+		for _, key := range keys {
+			if _, ok := model.FieldByName(key); !ok {
+				log.Fatalf("Opal.runTemplate: Key metatag error: %s has no field %s", temp.Model, key)
+			}
+		}
 
 		// Check each field if its an OPAL extract its metadata after we have the entity data
 		for i < model.NumField() {
@@ -165,7 +199,7 @@ func gatherTemplateData(pModels []Domain) {
 				if opalTags.Get("Name") == "" {
 					opalTags = Tag(fmt.Sprintf("Name: %q, %s", field.Name, opalTags))
 				}
-				if keys[field.Name] {
+				if keyIndex(keys, field.Name) >= 0 {
 					if typ.Name() == "AutoIncrement" {
 						opalTags += ", AutoIncrement: true"
 					}
@@ -180,6 +214,13 @@ func gatherTemplateData(pModels []Domain) {
 					if s == "slice" {
 						s = "[]byte"
 					}
+					if typ.Name() == "Version" {
+						opalTags += ", Version: true"
+					}
+					// Timestamps are stamped by convention
+					if typ.Name() == "Time" && (field.Name == "CreatedAt" || field.Name == "UpdatedAt" || field.Name == "DeletedAt") && opalTags.Get(field.Name) == "" {
+						opalTags += Tag(fmt.Sprintf(", %s: true", field.Name))
+					}
 					// TODO handle primitive types properly
 
 					temp.Columns = append(temp.Columns, TemplateField{field.Name, i, string(opalTags), getKind(typ.Name()), s})
@@ -187,26 +228,214 @@ func gatherTemplateData(pModels []Domain) {
 			}
 			i++
 		}
+
+		// Keys are bound in their declared order rather than field order
+		sort.SliceStable(temp.Keys, func(a, b int) bool {
+			return keyIndex(keys, temp.Keys[a].Name) < keyIndex(keys, temp.Keys[b].Name)
+		})
 		plate.Types[model.Name()] = &temp
 	}
 
-	// Gather relationship information
+	// Gather relationship information. The references each Model
+	// holds are gathered first as their inverse sides use them.
 	for _, domain := range pModels {
-		var i int
-		t := reflect.TypeOf(domain).Elem()
-
-		//		assoc := reflect.TypeOf((*Association)(nil)).Elem()
-		dom := reflect.TypeOf((*Domain)(nil)).Elem()
-		// Treat as a HasOne relationship
-		for i < t.NumField() {
-			if t.Field(i).Type.Implements(dom) {
-				// TODO gather key information for each model first
-				//
-			}
-			i++
+		gatherBelongsTo(plate, reflect.TypeOf(domain).Elem())
+	}
+	for _, domain := range pModels {
+		gatherHas(plate, reflect.TypeOf(domain).Elem())
+	}
+	return plate
+}
+
+// Gathers the Models a Model references. A reference is either
+// an unexported pointer to a Model such as owner *Person, which
+// is stored in the OwnerId field, or a field tagged with the
+// ModelName it references such as References: "domain.Person".
+func gatherBelongsTo(pPlate ModelTemplate, pType reflect.Type) {
+	temp := pPlate.Types[pType.Name()]
+	dom := reflect.TypeOf((*Domain)(nil)).Elem()
+	claimed := make(map[string]bool)
+	for i := 1; i < pType.NumField(); i++ {
+		field := pType.Field(i)
+		if field.Anonymous || field.Type.Kind() != reflect.Ptr || !field.Type.Implements(dom) {
+			continue
 		}
+		name := accessorName(field.Name)
+		fk := temp.column(name + "Id")
+		if fk == nil {
+			// Without a foreign key of its own the field is the
+			// inverse side of a reference and is a HasOne
+			continue
+		}
+		if field.PkgPath == "" {
+			log.Fatalf("Opal.gatherAssociations: %s.%s must be unexported as its accessor %s() uses its name", temp.Model, field.Name, field.Name)
+		}
+		related := relatedType(pPlate, temp, importName(field.Type.Elem()))
+
+		// The Cascade belongs to the association and the rest
+		// of the tags to the foreign key column
+		tag := ExtractOpalTags(field.Tag)
+		if columnTag := tag.without("Cascade"); columnTag != "" {
+			fk.Tag += ", " + string(columnTag)
+		}
+		if Tag(fk.Tag).Get("References") == "" {
+			fk.Tag += fmt.Sprintf(", References: %q", related.ImportName)
+		}
+		claimed[fk.Name] = true
+		association := belongsTo(temp, related, name, field.Name, fk.Name)
+		association.Cascade, _ = strconv.Unquote(tag.Get("Cascade"))
+		temp.BelongsTo = append(temp.BelongsTo, association)
+	}
+	fields := make([]TemplateField, 0, len(temp.Keys)+len(temp.Columns))
+	for _, key := range temp.Keys {
+		fields = append(fields, TemplateField{key.Name, key.Index, key.Tag, key.Kind, key.Primitive})
+	}
+	for _, field := range append(fields, temp.Columns...) {
+		references, _ := strconv.Unquote(Tag(field.Tag).Get("References"))
+		if references == "" || claimed[field.Name] {
+			continue
+		}
+		if !strings.HasSuffix(field.Name, "Id") || field.Name == "Id" {
+			log.Fatalf("Opal.gatherAssociations: %s.%s references %s so its name must end with Id", temp.Model, field.Name, references)
+		}
+		related := relatedType(pPlate, temp, references)
+		temp.BelongsTo = append(temp.BelongsTo, belongsTo(temp, related, strings.TrimSuffix(field.Name, "Id"), "", field.Name))
 	}
-	runTemplate(plate)
+}
+
+func belongsTo(pTemp, pRelated *TemplateType, pName, pField, pForeignKey string) AssociationField {
+	if len(pRelated.Keys) != 1 {
+		log.Fatalf("Opal.gatherAssociations: %s.%s references %s which does not have a single key", pTemp.Model, pForeignKey, pRelated.Model)
+	}
+	key := pRelated.Keys[0]
+	return AssociationField{
+		Name:       pName,
+		Field:      pField,
+		ForeignKey: pForeignKey,
+		Model:      pRelated.Model,
+		ImportName: pRelated.ImportName,
+		DAOName:    pRelated.DAOName,
+		Key:        key.Name,
+		Primitive:  key.Primitive,
+	}
+}
+
+// Gathers the Models which reference a Model. The inverse side
+// of a reference is an unexported slice such as pets []*Pet for
+// a HasMany or an unexported pointer without a foreign key field
+// such as passport *Passport for a HasOne. The foreign key is
+// one of the related Model's references and when it holds more
+// than one the field must choose with a ForeignKey tag. A slice
+// of a Model which does not reference the Model, or which is
+// tagged with a Through Model or JoinTable name, is a ManyToMany.
+func gatherHas(pPlate ModelTemplate, pType reflect.Type) {
+	temp := pPlate.Types[pType.Name()]
+	dom := reflect.TypeOf((*Domain)(nil)).Elem()
+	for i := 1; i < pType.NumField(); i++ {
+		field := pType.Field(i)
+		typ := field.Type
+		many := typ.Kind() == reflect.Slice
+		if many {
+			typ = typ.Elem()
+		}
+		if field.Anonymous || typ.Kind() != reflect.Ptr || !typ.Implements(dom) {
+			continue
+		}
+		name := accessorName(field.Name)
+		if !many && temp.column(name+"Id") != nil {
+			continue
+		}
+		if field.PkgPath == "" {
+			log.Fatalf("Opal.gatherAssociations: %s.%s must be unexported as its accessor %s() uses its name", temp.Model, field.Name, field.Name)
+		}
+		if len(temp.Keys) != 1 {
+			log.Fatalf("Opal.gatherAssociations: %s.%s cannot be related as %s does not have a single key", temp.Model, field.Name, temp.Model)
+		}
+		related := relatedType(pPlate, temp, importName(typ.Elem()))
+		key := temp.Keys[0]
+		association := AssociationField{
+			Name:       name,
+			Singular:   inflect.Singularize(name),
+			Field:      field.Name,
+			Model:      related.Model,
+			ImportName: related.ImportName,
+			DAOName:    related.DAOName,
+			Key:        key.Name,
+			Primitive:  key.Primitive,
+		}
+		tag := ExtractOpalTags(field.Tag)
+		association.Cascade, _ = strconv.Unquote(tag.Get("Cascade"))
+		choice, _ := strconv.Unquote(tag.Get("ForeignKey"))
+		found := inverseForeignKeys(temp, related, choice)
+		switch {
+		case many && choice == "" && (len(found) == 0 || tag.Get("Through") != "" || tag.Get("JoinTable") != ""):
+			association.Through = joinTable(temp, association, tag)
+			temp.ManyToMany = append(temp.ManyToMany, association)
+			continue
+		case len(found) == 0:
+			log.Fatalf("Opal.gatherAssociations: %s.%s has no foreign key in %s which references %s", temp.Model, field.Name, related.Model, temp.Model)
+		case len(found) > 1:
+			log.Fatalf("Opal.gatherAssociations: %s.%s must choose one of the foreign keys %v with a ForeignKey tag", temp.Model, field.Name, found)
+		}
+		association.ForeignKey = found[0]
+		if many {
+			temp.HasMany = append(temp.HasMany, association)
+		} else {
+			temp.HasOne = append(temp.HasOne, association)
+		}
+	}
+}
+
+// Finds the foreign keys on the related Model which reference
+// the Model optionally limited to a chosen key
+func inverseForeignKeys(pTemp, pRelated *TemplateType, pChoice string) (found []string) {
+	for _, reference := range pRelated.BelongsTo {
+		if reference.Model == pTemp.Model && (pChoice == "" || pChoice == reference.ForeignKey) {
+			found = append(found, reference.ForeignKey)
+		}
+	}
+	return
+}
+
+// Builds the JoinTable fields of a ManyToMany. A Through Model
+// supplies its own columns otherwise they are named after the
+// Model and the singular association e.g. ArticleId and TagId.
+func joinTable(pTemp *TemplateType, pAssociation AssociationField, pTag Tag) string {
+	if through := pTag.Get("Through"); through != "" {
+		return fmt.Sprintf("Model: %s", through)
+	}
+	join := fmt.Sprintf("Key: %q, ForeignKey: %q", pTemp.Model+"Id", pAssociation.Singular+"Id")
+	if name := pTag.Get("JoinTable"); name != "" {
+		join = fmt.Sprintf("Name: %s, %s", name, join)
+	}
+	return join
+}
+
+// Exports an association field name for its accessor
+func accessorName(pField string) string {
+	return strings.ToUpper(pField[:1]) + pField[1:]
+}
+
+// Gets the template data of a related Model which must be
+// in the same package as the Model
+func relatedType(pPlate ModelTemplate, pTemp *TemplateType, pImportName string) *TemplateType {
+	for _, temp := range pPlate.Types {
+		if temp.ImportName == pImportName && temp.Path == pTemp.Path {
+			return temp
+		}
+	}
+	log.Fatalf("Opal.gatherAssociations: %s references %s which is not a Model in package %s", pTemp.Model, pImportName, pTemp.Package)
+	return nil
+}
+
+// Gets the key or column template field by name or nil
+func (o *TemplateType) column(pName string) *TemplateField {
+	for i := range o.Columns {
+		if o.Columns[i].Name == pName {
+			return &o.Columns[i]
+		}
+	}
+	return nil
 }
 
 func runTemplate(pModels ModelTemplate) {
@@ -245,8 +474,31 @@ func runTemplate(pModels ModelTemplate) {
 // characters and Go string literal syntax.
 type Tag string
 
+// Matches a Key option of an Entity tag and the comma before it
+var tagKey = regexp.MustCompile(`(^|,)\s*Key:\s*("(\\.|[^"\\])*"|[^,]*)`)
+
+// Gets the Table options of an Entity tag. The Key is left out
+// as the keys are added apart and the Name defaults to the
+// tableized Model name.
+func tableTag(pTag Tag, pModel string) string {
+	table := strings.TrimLeft(tagKey.ReplaceAllString(string(pTag), ""), ", ")
+	if pTag.Get("Name") == "" {
+		name := fmt.Sprintf("Name: %q", inflect.Tableize(pModel))
+		if table == "" {
+			return name
+		}
+		return name + ", " + table
+	}
+	return table
+}
+
+// The opal tags may also be wrapped in an opal key such as
+// opal:"|Name: \"name\"|" which keeps go vet happy.
 func ExtractOpalTags(pStructTag reflect.StructTag) Tag {
 	tag := string(pStructTag)
+	if value, ok := pStructTag.Lookup("opal"); ok {
+		tag = value
+	}
 	if strings.Count(tag, "|") == 2 {
 		tag = strings.Split(tag, "|")[1]
 		return Tag(tag)
@@ -325,6 +577,49 @@ func (tag Tag) Get(key string) string {
 	return ""
 }
 
+// Removes a key and its value from the tag
+func (tag Tag) without(pKey string) Tag {
+	var parts []string
+	s := string(tag)
+	quoted, last := false, 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] == '"' && (i == 0 || s[i-1] != '\\') {
+			quoted = !quoted
+		}
+		if i < len(s) && (s[i] != ',' || quoted) {
+			continue
+		}
+		part := strings.TrimSpace(s[last:i])
+		last = i + 1
+		if part != "" && strings.TrimSpace(strings.SplitN(part, ":", 2)[0]) != pKey {
+			parts = append(parts, part)
+		}
+	}
+	return Tag(strings.Join(parts, ", "))
+}
+
+// Splits a compound key declaration such as "OrderId, LineNo"
+// into its field names keeping the declared order
+func splitKeys(pKey string) (keys []string) {
+	for _, key := range strings.Split(pKey, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// Gets the position of a field name in the declared keys
+// or -1 if it is not a key
+func keyIndex(pKeys []string, pField string) int {
+	for i, key := range pKeys {
+		if key == pField {
+			return i
+		}
+	}
+	return -1
+}
+
 // Helper to retrieve Type name and package name with which we
 // use to name a Model within the domain
 func importName(pType reflect.Type) string {
@@ -337,7 +632,7 @@ func getKind(pName string) string {
 		return "reflect.String"
 	case "Int64":
 		return "reflect.Int64"
-	case "AutoIncrement":
+	case "AutoIncrement", "Version":
 		return "reflect.Int64"
 	case "Float64":
 		return "reflect.Float64"
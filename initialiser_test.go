@@ -1,8 +1,15 @@
 package opal
 
 import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
 	"reflect"
 	"testing"
+	"text/template"
+
+	"bitbucket.org/pkg/inflect"
 )
 
 func TestExtractOpalTags(t *testing.T) {
@@ -14,6 +21,7 @@ func TestExtractOpalTags(t *testing.T) {
 		{`||`, ``},
 		{`|something|`, `something`},
 		{`json:"value"|with normal|`, `with normal`},
+		{`json:"value" opal:"|Name: \"name\"|"`, `Name: "name"`},
 	}
 	for _, tt := range tagOpalTests {
 		if v := ExtractOpalTags(tt.Tag); v != tt.Value {
@@ -59,3 +67,158 @@ func TestImportName(t *testing.T) {
 		t.Errorf("importName(%#q) = %#q, want %#q", r, s, "opal.T")
 	}
 }
+
+func TestTableTag(t *testing.T) {
+	name := fmt.Sprintf("Name: %q", inflect.Tableize("OrderLine"))
+	for _, test := range []struct {
+		tag  Tag
+		want string
+	}{
+		{``, name},
+		{`Name: "lines"`, `Name: "lines"`},
+		{`Key: "OrderId,LineNo"`, name},
+		{`Key: "OrderId,LineNo", Name: "lines"`, `Name: "lines"`},
+		{`Name: "lines", Key: "OrderId,LineNo", Schema: "sales"`, `Name: "lines", Schema: "sales"`},
+		{`Schema: "sales", Key: "Id"`, name + `, Schema: "sales"`},
+	} {
+		if got := tableTag(test.tag, "OrderLine"); got != test.want {
+			t.Errorf("tableTag(%#q) = %#q, want %#q", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	var splitKeysTests = []struct {
+		Key  string
+		Keys []string
+	}{
+		{`Id`, []string{"Id"}},
+		{`OrderId,LineNo`, []string{"OrderId", "LineNo"}},
+		{` LineNo , OrderId `, []string{"LineNo", "OrderId"}},
+		{`OrderId,,LineNo,`, []string{"OrderId", "LineNo"}},
+	}
+	for _, tt := range splitKeysTests {
+		if v := splitKeys(tt.Key); !reflect.DeepEqual(v, tt.Keys) {
+			t.Errorf("splitKeys(%#q) = %#v, want %#v", tt.Key, v, tt.Keys)
+		}
+	}
+}
+
+type genPerson struct {
+	Entity
+	Id       AutoIncrement
+	Name     String
+	pets     []*genPet `opal:"|ForeignKey: \"OwnerId\"|"`
+	passport *genPassport
+}
+
+type genPet struct {
+	Entity
+	Id      AutoIncrement
+	Name    String
+	OwnerId Int64
+	owner   *genPerson `opal:"|OnDelete: \"CASCADE\"|"`
+	VetId   Int64      `opal:"|References: \"opal.genPerson\"|"`
+}
+
+type genPassport struct {
+	Entity
+	Id       AutoIncrement
+	Number   String
+	PersonId Int64 `opal:"|References: \"opal.genPerson\"|"`
+}
+
+type genArticle struct {
+	Entity
+	Id      AutoIncrement
+	Title   String
+	tags    []*genTag
+	authors []*genPerson `opal:"|Through: \"opal.genAuthorship\"|"`
+}
+
+type genTag struct {
+	Entity
+	Id   AutoIncrement
+	Name String
+}
+
+type genAuthorship struct {
+	Entity
+	Id        AutoIncrement
+	ArticleId Int64 `opal:"|References: \"opal.genArticle\"|"`
+	PersonId  Int64 `opal:"|References: \"opal.genPerson\"|"`
+}
+
+// Runs the entity template over the gathered data checking
+// the generated code is valid Go
+func executeTemplate(t *testing.T, pPlate ModelTemplate) {
+	b, err := ioutil.ReadFile("entity.template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("Model setup").Parse(string(b)))
+	for name, temp := range pPlate.Types {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, temp); err != nil {
+			t.Fatalf("executing %s: %s", name, err)
+		}
+		if _, err := format.Source(buf.Bytes()); err != nil {
+			t.Fatalf("generated %s: %s\n%s", name, err, buf.String())
+		}
+	}
+}
+
+func TestGatherBelongsTo(t *testing.T) {
+	plate := gatherTemplateData([]Domain{new(genPerson), new(genPet), new(genPassport)})
+	pet := plate.Types["genPet"]
+	dao := plate.Types["genPerson"].DAOName
+	want := []AssociationField{
+		{Name: "Owner", Field: "owner", ForeignKey: "OwnerId", Model: "genPerson", ImportName: "opal.genPerson", DAOName: dao, Key: "Id", Primitive: "int64"},
+		{Name: "Vet", ForeignKey: "VetId", Model: "genPerson", ImportName: "opal.genPerson", DAOName: dao, Key: "Id", Primitive: "int64"},
+	}
+	if !reflect.DeepEqual(pet.BelongsTo, want) {
+		t.Errorf("BelongsTo = %v, want %v", pet.BelongsTo, want)
+	}
+	tag := Tag(pet.column("OwnerId").Tag)
+	if tag.Get("References") != `"opal.genPerson"` || tag.Get("OnDelete") != `"CASCADE"` {
+		t.Errorf("OwnerId tag = %#q, want the reference and its actions", tag)
+	}
+	executeTemplate(t, plate)
+}
+
+func TestGatherHas(t *testing.T) {
+	plate := gatherTemplateData([]Domain{new(genPerson), new(genPet), new(genPassport)})
+	person := plate.Types["genPerson"]
+	many := []AssociationField{
+		{Name: "Pets", Singular: "Pet", Field: "pets", ForeignKey: "OwnerId", Model: "genPet", ImportName: "opal.genPet", DAOName: plate.Types["genPet"].DAOName, Key: "Id", Primitive: "int64"},
+	}
+	if !reflect.DeepEqual(person.HasMany, many) {
+		t.Errorf("HasMany = %v, want %v", person.HasMany, many)
+	}
+	one := []AssociationField{
+		{Name: "Passport", Singular: "Passport", Field: "passport", ForeignKey: "PersonId", Model: "genPassport", ImportName: "opal.genPassport", DAOName: plate.Types["genPassport"].DAOName, Key: "Id", Primitive: "int64"},
+	}
+	if !reflect.DeepEqual(person.HasOne, one) {
+		t.Errorf("HasOne = %v, want %v", person.HasOne, one)
+	}
+	if len(person.BelongsTo) != 0 {
+		t.Errorf("BelongsTo = %v, want the inverse sides left out", person.BelongsTo)
+	}
+	executeTemplate(t, plate)
+}
+
+func TestGatherManyToMany(t *testing.T) {
+	plate := gatherTemplateData([]Domain{new(genPerson), new(genPet), new(genPassport), new(genArticle), new(genTag), new(genAuthorship)})
+	article := plate.Types["genArticle"]
+	if len(article.ManyToMany) != 2 || len(article.HasMany) != 0 {
+		t.Fatalf("ManyToMany = %v, HasMany = %v", article.ManyToMany, article.HasMany)
+	}
+	tags, authors := article.ManyToMany[0], article.ManyToMany[1]
+	if tags.Name != "Tags" || tags.Singular != "Tag" || tags.Through != `Key: "genArticleId", ForeignKey: "TagId"` {
+		t.Errorf("Tags = %+v", tags)
+	}
+	if authors.Model != "genPerson" || authors.Through != `Model: "opal.genAuthorship"` {
+		t.Errorf("Authors = %+v", authors)
+	}
+	executeTemplate(t, plate)
+}
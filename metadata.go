@@ -5,11 +5,12 @@
 package opal
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ModelMetadata struct {
@@ -17,19 +18,42 @@ type ModelMetadata struct {
 	this  reflect.Type
 	table Table
 
-	// The metadata columns
-	columns            []Column
-	columnsByIndex     map[int]*Column
-	columnsByFieldName map[string]*Column
-	keysByIndex        map[int]*Column
-	keysByFieldName    map[string]*Column
-	// TODO maps are not guaranteed to keep insert order
-	// Sort an int array
-	// Also combine two maps where necessary here for
-	// easier insertion of parameters
+	// The metadata columns in the order they were added
+	columns []Column
 
-	// Prepared query store
-	preparedStatements map[string]*sql.Stmt
+	// Positions in columns partitioned into the primary keys,
+	// in their declared order, and all other columns in the
+	// order they were added. All sql generation and bind args
+	// follow these so values always line up with their columns.
+	keys    []int
+	nonKeys []int
+
+	// Positions in columns by the domain field name and index
+	columnsByIndex     map[int]int
+	columnsByFieldName map[string]int
+
+	// Secondary indexes in the order they were declared
+	indexes []Index
+
+	// References to the primary keys of other Models
+	foreignKeys []ForeignKey
+
+	// Related Models in the order they were declared
+	associations []Association
+
+	// Conditions of every select, update and delete
+	scopes []Scope
+
+	// Whether generated columns are read back through a
+	// RETURNING clause rather than a follow up find
+	returning bool
+
+	// The clock which stamps the timestamp columns
+	clock func() time.Time
+
+	// The sql of each named statement which the Gem prepares
+	// on each connection pool on first use
+	statements map[string]string
 
 	Service ModelDAO
 }
@@ -39,23 +63,16 @@ func NewMetadata(pModel Model, pType reflect.Type) *ModelMetadata {
 	o := new(ModelMetadata)
 	o.this = pType
 	o.Model = pModel
-	o.columnsByIndex = make(map[int]*Column)
-	o.columnsByFieldName = make(map[string]*Column)
-	o.keysByIndex = make(map[int]*Column)
-	o.keysByFieldName = make(map[string]*Column)
-	o.preparedStatements = make(map[string]*sql.Stmt)
+	o.columnsByIndex = make(map[int]int)
+	o.columnsByFieldName = make(map[string]int)
+	o.statements = make(map[string]string)
 	return o
 }
 
-func (o *ModelMetadata) addStmt(pDB *sql.DB, pKey string, pValue Sql) {
-	sql := pValue.String()
-	log.Printf("Opal.ModelMetadata.addStmt: %s", sql)
-	stmt, err := pDB.Prepare(sql)
-	if err != nil {
-		panic(err) // TODO wtf?
-	}
-	o.preparedStatements[pKey] = stmt
-	//log.Printf("Opal.ModelMetadata.addStmt: %#v", o.preparedStatements[pKey])
+func (o *ModelMetadata) addStmt(pKey string, pValue Sql) {
+	query := pValue.String()
+	log.Printf("Opal.ModelMetadata.addStmt: %s", query)
+	o.statements[pKey] = query
 }
 
 // Get the columns metadata
@@ -75,12 +92,12 @@ func (o ModelMetadata) ColumnByIndex(index int) Column {
 
 // Get the column metadata by the domains field name
 func (o ModelMetadata) Column(pField string) Column {
-	return *o.columnsByFieldName[pField]
+	return o.columns[o.columnsByFieldName[pField]]
 }
 
 // Get the column metadata by the domains field index
 func (o ModelMetadata) ColumnByFieldIndex(pIndex int) Column {
-	return *o.columnsByIndex[pIndex]
+	return o.columns[o.columnsByIndex[pIndex]]
 }
 
 //  Get the type of the entity domain parent
@@ -88,12 +105,294 @@ func (o ModelMetadata) Type() reflect.Type {
 	return o.this
 }
 
-// TODO
+// Adds the table metadata. The key field names are listed in the
+// order in which compound keys are bound and declared.
 func (o *ModelMetadata) AddTable(pTable Table, pKeyFieldNames ...string) {
 	o.table = pTable
 	o.table.Key = pKeyFieldNames
 }
 
+// Gets the secondary indexes and unique constraints
+func (o ModelMetadata) Indexes() []Index {
+	return append([]Index(nil), o.indexes...)
+}
+
+// Gets the references to other Models
+func (o ModelMetadata) ForeignKeys() []ForeignKey {
+	return append([]ForeignKey(nil), o.foreignKeys...)
+}
+
+// Adds a relationship to another Model
+func (o *ModelMetadata) AddAssociation(pAssociation Association) {
+	o.associations = append(o.associations, pAssociation)
+}
+
+// Gets the relationships to other Models
+func (o ModelMetadata) Associations() []Association {
+	return append([]Association(nil), o.associations...)
+}
+
+// Gets a relationship to another Model by its name
+func (o ModelMetadata) Association(pName string) (Association, bool) {
+	for _, association := range o.associations {
+		if association.Name == pName {
+			return association, true
+		}
+	}
+	return Association{}, false
+}
+
+// Reports whether any association cascades any of the options
+func (o ModelMetadata) cascades(pOptions ...string) bool {
+	for _, association := range o.associations {
+		if association.cascades(pOptions...) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks each association relates to a known Model through a
+// foreign key field on the side of the relationship which holds
+// it and resolves the join table of each ManyToMany
+func (o *ModelMetadata) resolveAssociations(pName ModelName, pMetas map[ModelName]*ModelMetadata) {
+	for i, association := range o.associations {
+		meta, ok := pMetas[association.Model]
+		if !ok {
+			panic(fmt.Sprintf("Opal.Start: %s association %s relates to unknown Model %s", o.table.Name, association.Name, association.Model))
+		}
+		for _, option := range splitKeys(association.Cascade) {
+			switch {
+			case option == CascadeSave:
+			case option != CascadeDelete && option != CascadeNullify:
+				panic(fmt.Sprintf("Opal.Start: %s association %s has unknown cascade %s", o.table.Name, association.Name, option))
+			case association.Kind == BelongsTo:
+				panic(fmt.Sprintf("Opal.Start: %s association %s cannot cascade %s to the Model it references", o.table.Name, association.Name, option))
+			}
+		}
+		if association.Kind == ManyToMany {
+			o.associations[i].Through = o.resolveJoinTable(pName, association, meta, pMetas)
+			continue
+		}
+		holder := meta
+		if association.Kind == BelongsTo {
+			holder = o
+		}
+		if _, ok := holder.columnsByFieldName[association.ForeignKey]; !ok {
+			panic(fmt.Sprintf("Opal.Start: %s association %s has no foreign key field %s", o.table.Name, association.Name, association.ForeignKey))
+		}
+	}
+}
+
+// Resolves the table and columns linking both sides of a ManyToMany.
+// A join table Opal creates is named after both tables so either side
+// may declare it. A join Model's columns are its foreign keys to each
+// side in the order they were added.
+func (o *ModelMetadata) resolveJoinTable(pName ModelName, pAssociation Association, pRelated *ModelMetadata, pMetas map[ModelName]*ModelMetadata) JoinTable {
+	if len(o.keys) != 1 || len(pRelated.keys) != 1 {
+		panic(fmt.Sprintf("Opal.Start: %s association %s requires both Models to have a single key", o.table.Name, pAssociation.Name))
+	}
+	join := pAssociation.Through
+	if join.Model == "" {
+		if join.Name == "" {
+			tables := []string{o.table.Name, pRelated.table.Name}
+			sort.Strings(tables)
+			join.Name = tables[0] + "_" + tables[1]
+		}
+		if join.Key == "" {
+			join.Key = pName.Name() + "Id"
+		}
+		if join.ForeignKey == "" {
+			join.ForeignKey = pAssociation.Model.Name() + "Id"
+		}
+		return join
+	}
+	through, ok := pMetas[join.Model]
+	if !ok {
+		panic(fmt.Sprintf("Opal.Start: %s association %s is through unknown Model %s", o.table.Name, pAssociation.Name, join.Model))
+	}
+	join.Name = through.table.Name
+	for _, fk := range through.foreignKeys {
+		switch {
+		case fk.References == pName && join.Key == "":
+			join.Key = fk.Column
+		case fk.References == pAssociation.Model && join.ForeignKey == "":
+			join.ForeignKey = fk.Column
+		}
+	}
+	if join.Key == "" || join.ForeignKey == "" {
+		panic(fmt.Sprintf("Opal.Start: %s does not reference both %s and %s", join.Model, pName, pAssociation.Model))
+	}
+	return join
+}
+
+// Creates the metadata of a join table Opal maintains. Its
+// compound key references the keys of both sides.
+func joinMetadata(pJoin JoinTable, pName ModelName, pMeta *ModelMetadata, pRelated ModelName, pRelatedMeta *ModelMetadata) *ModelMetadata {
+	o := NewMetadata(nil, nil)
+	o.AddTable(Table{Name: pJoin.Name}, pJoin.Key, pJoin.ForeignKey)
+	key, related := pMeta.Keys()[0], pRelatedMeta.Keys()[0]
+	for i, column := range []Column{
+		{Identifier: pJoin.Key, Name: pJoin.Key, Kind: key.Kind, Length: key.Length, References: pName},
+		{Identifier: pJoin.ForeignKey, Name: pJoin.ForeignKey, Kind: related.Kind, Length: related.Length, References: pRelated},
+	} {
+		column.Insertable = true
+		column.OnDelete = "CASCADE"
+		o.addColumn(i, column)
+		o.keys = append(o.keys, i)
+	}
+	return o
+}
+
+// Resolves the table and key column of each foreign key from
+// the metadata of the referenced Model
+func (o *ModelMetadata) resolveForeignKeys(pMetas map[ModelName]*ModelMetadata) {
+	for i, fk := range o.foreignKeys {
+		meta, ok := pMetas[fk.References]
+		if !ok {
+			panic(fmt.Sprintf("Opal.Start: %s references unknown Model %s", o.table.Name, fk.References))
+		}
+		keys := meta.Keys()
+		if len(keys) != 1 {
+			panic(fmt.Sprintf("Opal.Start: %s references %s which does not have a single key", o.table.Name, fk.References))
+		}
+		o.foreignKeys[i].Table = meta.table.Name
+		o.foreignKeys[i].Key = keys[0].Name
+	}
+}
+
+// Gets the primary key columns in their declared order
+func (o ModelMetadata) Keys() []Column {
+	return o.columnsAt(o.keys)
+}
+
+// Gets the columns which are not part of the primary key
+// in the order they were added
+func (o ModelMetadata) NonKeys() []Column {
+	return o.columnsAt(o.nonKeys)
+}
+
+// Gets all columns with the keys first. This is the order
+// in which Models bind and scan their values.
+func (o ModelMetadata) orderedColumns() []Column {
+	return append(o.Keys(), o.NonKeys()...)
+}
+
+// Gets the columns which are bound when a Model is inserted
+func (o ModelMetadata) insertColumns() (columns []Column) {
+	for _, column := range o.orderedColumns() {
+		if column.Insertable {
+			columns = append(columns, column)
+		}
+	}
+	return
+}
+
+// Gets the non key columns which are bound when a Model is updated
+func (o ModelMetadata) updateColumns() (columns []Column) {
+	for _, column := range o.NonKeys() {
+		if column.Updatable {
+			columns = append(columns, column)
+		}
+	}
+	return
+}
+
+// Gets the column which versions the Model's rows if it has one
+func (o ModelMetadata) versionColumn() (Column, bool) {
+	for _, column := range o.NonKeys() {
+		if column.Version {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// Gets the column which soft deletes the Model's rows if it has one
+func (o ModelMetadata) deletedColumn() (Column, bool) {
+	for _, column := range o.NonKeys() {
+		if column.DeletedAt {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// Gets the name of the variant of a find statement for the
+// deleted scope. Models without a DeletedAt column have none.
+func (o ModelMetadata) scopedStmt(pNamedStmt string, pScope deletedScope) string {
+	if _, ok := o.deletedColumn(); !ok {
+		return pNamedStmt
+	}
+	switch pScope {
+	case withDeleted:
+		return pNamedStmt + "WithDeleted"
+	case onlyDeleted:
+		return pNamedStmt + "OnlyDeleted"
+	}
+	return pNamedStmt
+}
+
+// Scopes the Model's statements by the field which is no
+// longer updatable. Scope fields must be added first.
+func (o *ModelMetadata) AddScope(pScope Scope) {
+	position, ok := o.columnsByFieldName[pScope.Field]
+	if !ok {
+		panic(fmt.Sprintf("Opal.ModelMetadata: %s has no field %s to scope", o.table.Name, pScope.Field))
+	}
+	o.columns[position].Updatable = false
+	o.scopes = append(o.scopes, pScope)
+}
+
+// Gets the time from the Model's clock
+func (o ModelMetadata) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock()
+}
+
+// Gets the columns which the database fills on every write
+func (o ModelMetadata) generatedColumns() (columns []Column) {
+	for _, column := range o.orderedColumns() {
+		if column.Generated {
+			columns = append(columns, column)
+		}
+	}
+	return
+}
+
+// Gets the columns which are read back after an insert.
+// These are the generated columns and any auto increment key.
+func (o ModelMetadata) insertReturnColumns() (columns []Column) {
+	for _, column := range o.orderedColumns() {
+		if column.Generated || column.AutoIncrement {
+			columns = append(columns, column)
+		}
+	}
+	return
+}
+
+// Filters a Model's bind args, which must be in the same order
+// as the columns, down to those whose column is included
+func filterArgs(pArgs []interface{}, pColumns []Column, fInclude func(Column) bool) []interface{} {
+	args := make([]interface{}, 0, len(pArgs))
+	for i, arg := range pArgs {
+		if fInclude(pColumns[i]) {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+func (o ModelMetadata) columnsAt(pPositions []int) []Column {
+	columns := make([]Column, len(pPositions))
+	for i, position := range pPositions {
+		columns[i] = o.columns[position]
+	}
+	return columns
+}
+
 type GenerationType int
 
 const (
@@ -118,7 +417,8 @@ func (o *ModelMetadata) AddKey(pField string, pIndex int, pColumn Column, pKind
 	//	}
 	tag := ExtractOpalTags(o.this.Field(pIndex).Tag)
 	// Set the default values if not specified
-	c := Column{Length: 255, Insertable: true, Updatable: true, Nilable: true}
+	// Generated columns are left to the database unless specified
+	c := Column{Length: 255, Insertable: !pColumn.Generated, Updatable: !pColumn.Generated, Nilable: true}
 	if tag.Get("Nilable") != "" {
 		c.Nilable = pColumn.Nilable
 	}
@@ -140,11 +440,35 @@ func (o *ModelMetadata) AddKey(pField string, pIndex int, pColumn Column, pKind
 	c.Precision = pColumn.Precision
 	c.Scale = pColumn.Scale
 	c.AutoIncrement = pColumn.AutoIncrement
+	c.Default = pColumn.Default
+	c.References = pColumn.References
+	c.OnDelete = pColumn.OnDelete
+	c.OnUpdate = pColumn.OnUpdate
+	c.Index = pColumn.Index
+	c.UniqueIndex = pColumn.UniqueIndex
+	c.Generated = pColumn.Generated
+	c.Version = pColumn.Version
+	c.CreatedAt = pColumn.CreatedAt
+	c.UpdatedAt = pColumn.UpdatedAt
+	c.DeletedAt = pColumn.DeletedAt
 	c.Kind = pKind
+	if c.Version {
+		if _, ok := o.versionColumn(); ok || pKind != reflect.Int64 {
+			panic(fmt.Sprintf("Opal.ModelMetadata: %s version %s must be the only version and an Int64", o.table.Name, pField))
+		}
+	}
 
-	o.columns = append(o.columns, c)
-	o.keysByFieldName[pField] = &o.columns[len(o.columns)-1]
-	o.keysByIndex[pIndex] = o.keysByFieldName[pField]
+	o.addColumn(pIndex, c)
+	o.keys = append(o.keys, len(o.columns)-1)
+
+	// Keys not declared through AddTable keep the order they are added
+	if keyIndex(o.table.Key, pField) < 0 {
+		o.table.Key = append(o.table.Key, pField)
+	}
+	sort.SliceStable(o.keys, func(i, j int) bool {
+		return keyIndex(o.table.Key, o.columns[o.keys[i]].Identifier) <
+			keyIndex(o.table.Key, o.columns[o.keys[j]].Identifier)
+	})
 }
 
 // TODO
@@ -152,7 +476,8 @@ func (o *ModelMetadata) AddColumn(pField string, pIndex int, pColumn Column, pKi
 	tag := ExtractOpalTags(o.this.Field(pIndex).Tag)
 
 	// Set the default values if not specified
-	c := Column{Insertable: true, Length: 255, Nilable: true, Updatable: true}
+	// Generated columns are left to the database unless specified
+	c := Column{Insertable: !pColumn.Generated, Length: 255, Nilable: true, Updatable: !pColumn.Generated}
 	if tag.Get("Nilable") != "" {
 		c.Nilable = pColumn.Nilable
 	}
@@ -173,11 +498,72 @@ func (o *ModelMetadata) AddColumn(pField string, pIndex int, pColumn Column, pKi
 	c.Unique = pColumn.Unique
 	c.Precision = pColumn.Precision
 	c.Scale = pColumn.Scale
+	c.Default = pColumn.Default
+	c.References = pColumn.References
+	c.OnDelete = pColumn.OnDelete
+	c.OnUpdate = pColumn.OnUpdate
+	c.Index = pColumn.Index
+	c.UniqueIndex = pColumn.UniqueIndex
+	c.Generated = pColumn.Generated
+	c.Version = pColumn.Version
+	c.CreatedAt = pColumn.CreatedAt
+	c.UpdatedAt = pColumn.UpdatedAt
+	c.DeletedAt = pColumn.DeletedAt
 	c.Kind = pKind
+	if c.Version {
+		if _, ok := o.versionColumn(); ok || pKind != reflect.Int64 {
+			panic(fmt.Sprintf("Opal.ModelMetadata: %s version %s must be the only version and an Int64", o.table.Name, pField))
+		}
+	}
+
+	if c.CreatedAt || c.UpdatedAt || c.DeletedAt {
+		if pKind != OpalTime {
+			panic(fmt.Sprintf("Opal.ModelMetadata: %s timestamp %s must be a Time", o.table.Name, pField))
+		}
+		// The creation time is never rewritten and the deletion
+		// time is only written by a delete
+		if c.CreatedAt || c.DeletedAt {
+			c.Updatable = false
+		}
+	}
+	if _, ok := o.deletedColumn(); ok && c.DeletedAt {
+		panic(fmt.Sprintf("Opal.ModelMetadata: %s has more than one DeletedAt column", o.table.Name))
+	}
 
-	o.columns = append(o.columns, c)
-	o.columnsByFieldName[pField] = &o.columns[len(o.columns)-1]
-	o.columnsByIndex[pIndex] = o.columnsByFieldName[pField]
+	o.addColumn(pIndex, c)
+	o.nonKeys = append(o.nonKeys, len(o.columns)-1)
+}
+
+func (o *ModelMetadata) addColumn(pIndex int, pColumn Column) {
+	o.columns = append(o.columns, pColumn)
+	o.columnsByFieldName[pColumn.Identifier] = len(o.columns) - 1
+	o.columnsByIndex[pIndex] = len(o.columns) - 1
+	if pColumn.References != "" {
+		o.foreignKeys = append(o.foreignKeys, ForeignKey{
+			Column:     pColumn.Name,
+			References: pColumn.References,
+			OnDelete:   pColumn.OnDelete,
+			OnUpdate:   pColumn.OnUpdate,
+		})
+	}
+	for _, name := range splitKeys(pColumn.Index) {
+		o.addIndex(name, false, pColumn.Name)
+	}
+	for _, name := range splitKeys(pColumn.UniqueIndex) {
+		o.addIndex(name, true, pColumn.Name)
+	}
+}
+
+// Adds the column to the named index creating it if required
+func (o *ModelMetadata) addIndex(pName string, pUnique bool, pColumn string) {
+	for i := range o.indexes {
+		if o.indexes[i].Name == pName {
+			o.indexes[i].Unique = o.indexes[i].Unique || pUnique
+			o.indexes[i].Columns = append(o.indexes[i].Columns, pColumn)
+			return
+		}
+	}
+	o.indexes = append(o.indexes, Index{pName, pUnique, []string{pColumn}})
 }
 
 // TODO
@@ -193,9 +579,10 @@ func (o *ModelMetadata) ReplaceColumnIdentifiers(sql string, fDialect DialectEnc
 	return sql
 }
 
-// TODO
+// Adds columns onto a sql builder in the form
+// Key, Name,... with the keys first
 func (o *ModelMetadata) ColumnsList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
-	for _, column := range o.columns {
+	for _, column := range o.orderedColumns() {
 		pBuilder.Add(column.Name).Add(", ")
 	}
 	return pBuilder.Truncate(2)
@@ -210,35 +597,83 @@ func (o *ModelMetadata) ColumnsBindList(pBuilder *SqlBuilder, fDialect DialectEn
 	return pBuilder.Truncate(2)
 }
 
-// sqlite seems to ignore incorrect set pk bindings when updating
-// TODO need to ignore primary keys columns
+// Adds the insertable columns onto a sql builder in the form
+// Key, Name,... with the keys first
+func (o *ModelMetadata) InsertableColumnsList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
+	for _, column := range o.insertColumns() {
+		pBuilder.Add(column.Name).Add(", ")
+	}
+	return pBuilder.Truncate(2)
+}
+
+// Adds a bind for each insertable column onto a sql builder
+// in the form ?, ?...
+func (o *ModelMetadata) InsertableColumnsBindList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
+	for range o.insertColumns() {
+		pBuilder.Add("?, ")
+	}
+	return pBuilder.Truncate(2)
+}
+
+// Adds the updatable non key columns onto a sql builder in the
+// form Name = ?, Name = ?... in the order they were added
+func (o *ModelMetadata) UpdatableListEqualsUpdatableBindList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
+	for _, column := range o.updateColumns() {
+		pBuilder.Add(column.Name).Add(" = ?, ")
+	} // TODO the assumption is there is always an updatable column
+	return pBuilder.Truncate(2)
+}
+
+// Adds the columns read back after an insert onto a sql builder
+// in the form Key, Name,...
+func (o *ModelMetadata) InsertReturnList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
+	for _, column := range o.insertReturnColumns() {
+		pBuilder.Add(column.Name).Add(", ")
+	}
+	return pBuilder.Truncate(2)
+}
+
+// Adds the generated columns onto a sql builder in the
+// form Name, Name,...
+func (o *ModelMetadata) GeneratedList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
+	for _, column := range o.generatedColumns() {
+		pBuilder.Add(column.Name).Add(", ")
+	}
+	return pBuilder.Truncate(2)
+}
+
+// Adds the non key columns onto a sql builder in the form
+// Name = ?, Name = ?... in the order they were added
 func (o *ModelMetadata) NonKeyListEqualsNonKeyBindList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
-	for _, column := range o.columnsByFieldName {
+	for _, column := range o.NonKeys() {
 		pBuilder.Add(column.Name).Add(" = ?, ")
 	} // TODO the assumption is there is always a column
 	return pBuilder.Truncate(2)
 }
 
-// TODO
+// Adds all columns onto a sql builder in the form
+// Key = ? AND Name = ?... with the keys first
 func (o *ModelMetadata) ColumnsListEqualsColumnsBindList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
-	for _, column := range o.columns {
+	for _, column := range o.orderedColumns() {
 		pBuilder.Add(column.Name).Add(" = ? AND ")
 	}
 	return pBuilder.Truncate(5)
 }
 
-// TODO
+// Adds the keys onto a sql builder in the form
+// Key = ? AND Key = ?... in the declared key order
 func (o *ModelMetadata) KeyListEqualsKeyBindList(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
-	for _, key := range o.keysByFieldName {
+	for _, key := range o.Keys() {
 		pBuilder.Add(key.Name).Add(" = ? AND ")
 	}
 	return pBuilder.Truncate(5)
 }
 
-// TODO
+// Adds the column definitions onto a sql builder with the
+// keys first followed by the table constraints
 func (o *ModelMetadata) ColumnListWithConstraints(pBuilder *SqlBuilder, fDialect DialectEncoder) *SqlBuilder {
-	if len(o.keysByFieldName) == 1 {
-		for _, key := range o.keysByIndex {
+	if len(o.keys) == 1 {
+		for _, key := range o.Keys() {
 			if key.Kind == reflect.Int64 {
 				pBuilder.Add(key.Name)
 				pBuilder.Add(" INTEGER NOT NULL PRIMARY KEY")
@@ -252,13 +687,24 @@ func (o *ModelMetadata) ColumnListWithConstraints(pBuilder *SqlBuilder, fDialect
 			// TODO handle other types and compound keys and different dialects
 		}
 	} else {
-		for _, key := range o.keysByFieldName {
-			key.BuildColumnSchema(pBuilder).Add(", ")
+		for _, key := range o.Keys() {
+			key.BuildCompoundKeySchema(pBuilder).Add(", ")
 		}
 	}
-	for _, column := range o.columnsByFieldName {
+	for _, column := range o.NonKeys() {
 		column.BuildColumnSchema(pBuilder).Add(", ")
 	}
+	if len(o.keys) > 1 {
+		// Compound keys are a table constraint
+		pBuilder.Add("PRIMARY KEY (")
+		for _, key := range o.Keys() {
+			pBuilder.Add(key.Name).Add(", ")
+		}
+		pBuilder.Truncate(2).Add("), ")
+	}
+	for _, fk := range o.foreignKeys {
+		fk.BuildConstraint(pBuilder).Add(", ")
+	}
 	return pBuilder.Truncate(2)
 }
 
@@ -274,7 +720,44 @@ type Column struct {
 	Length        uint
 	Precision     uint
 	Scale         uint
-	Kind          reflect.Kind
+
+	// The sql expression the column defaults to
+	Default string
+
+	// Generated columns are filled by the database through
+	// defaults, triggers or computation and are read back
+	// into the Model after it is written
+	Generated bool
+
+	// Version columns are incremented by every update which
+	// only matches the row while it holds the version the
+	// Model was read with. See ErrStaleModel.
+	Version bool
+
+	// Timestamp columns Opal stamps from the Gem's clock. The
+	// CreatedAt column is stamped on insert when it is empty and
+	// never updated; the UpdatedAt column on every write.
+	CreatedAt bool
+	UpdatedAt bool
+
+	// A DeletedAt column soft deletes the Model. Deletes set it
+	// rather than removing the row and selects leave out the
+	// rows where it is set unless scoped otherwise.
+	DeletedAt bool
+
+	// Comma separated names of the indexes the column is part
+	// of. Columns sharing a name are indexed together in the
+	// order they were added.
+	Index       string
+	UniqueIndex string
+
+	// The Model whose primary key the column references and
+	// the referential actions e.g. CASCADE or SET NULL
+	References ModelName
+	OnDelete   string
+	OnUpdate   string
+
+	Kind reflect.Kind
 }
 
 // TODO
@@ -282,6 +765,7 @@ func (o Column) BuildColumnSchema(pBuilder *SqlBuilder) *SqlBuilder {
 	pBuilder.Add(o.Name).Add(o.ToSqlType())
 	o.unique(pBuilder)
 	o.nilable(pBuilder)
+	o.defaultValue(pBuilder)
 	return pBuilder
 }
 
@@ -291,6 +775,13 @@ func (o Column) BuildKeySchema(pBuilder *SqlBuilder) *SqlBuilder {
 	return pBuilder
 }
 
+// Builds a column which is part of a compound key. The PRIMARY KEY
+// constraint is added to the table rather than the column.
+func (o Column) BuildCompoundKeySchema(pBuilder *SqlBuilder) *SqlBuilder {
+	pBuilder.Add(o.Name).Add(o.ToSqlType()).Add(" NOT NULL")
+	return pBuilder
+}
+
 func (o Column) unique(pBuilder *SqlBuilder) {
 	if o.Unique {
 		pBuilder.Add(" UNIQUE")
@@ -303,6 +794,12 @@ func (o Column) nilable(pBuilder *SqlBuilder) {
 	}
 }
 
+func (o Column) defaultValue(pBuilder *SqlBuilder) {
+	if o.Default != "" {
+		pBuilder.Add(" DEFAULT ").Add(o.Default)
+	}
+}
+
 // TODO
 func (o Column) ToSqlType() string {
 	switch o.Kind {
@@ -336,6 +833,99 @@ func (o Column) ToSqlType() string {
 	return fmt.Sprintf(" VARCHAR(%d)", o.Length)
 }
 
+// Index is a named secondary index or unique constraint
+// over one or more columns
+type Index struct {
+	Name    string
+	Unique  bool
+	Columns []string
+}
+
+// ForeignKey is a column referencing the primary key of another
+// Model. The referenced Table and Key are resolved when the Gem
+// starts.
+type ForeignKey struct {
+	Column     string
+	References ModelName
+	Table      string
+	Key        string
+	OnDelete   string
+	OnUpdate   string
+}
+
+// Adds the foreign key constraint onto a sql builder
+func (o ForeignKey) BuildConstraint(pBuilder *SqlBuilder) *SqlBuilder {
+	pBuilder.Add("FOREIGN KEY (").Add(o.Column).Add(") REFERENCES ")
+	pBuilder.Add(o.Table).Add(" (").Add(o.Key).Add(")")
+	if o.OnDelete != "" {
+		pBuilder.Add(" ON DELETE ").Add(o.OnDelete)
+	}
+	if o.OnUpdate != "" {
+		pBuilder.Add(" ON UPDATE ").Add(o.OnUpdate)
+	}
+	return pBuilder
+}
+
+// AssociationKind identifies which side of a foreign key
+// a related Model is on
+type AssociationKind int
+
+const (
+	// The Model holds the foreign key to the related Model
+	BelongsTo AssociationKind = iota
+
+	// The related Model holds the foreign key to the Model
+	HasOne
+	HasMany
+
+	// The Models are linked through a join table
+	ManyToMany
+)
+
+// Association relates a Model to another Model through a
+// foreign key. The ForeignKey is the field name of the key
+// which is on the Model for BelongsTo and on the related
+// Model for HasOne and HasMany. A ManyToMany is related
+// Through a join table instead. Cascade is a comma separated
+// list of the writes which carry on to the related Models.
+type Association struct {
+	Name       string
+	Kind       AssociationKind
+	Model      ModelName
+	ForeignKey string
+	Through    JoinTable
+	Cascade    string
+}
+
+// Reports whether the association cascades any of the options
+func (o Association) cascades(pOptions ...string) bool {
+	for _, cascade := range splitKeys(o.Cascade) {
+		for _, option := range pOptions {
+			if cascade == option {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JoinTable links the keys of both sides of a ManyToMany. Opal
+// creates and names the table unless it is the table of a join
+// Model which references both sides.
+type JoinTable struct {
+	Model ModelName
+	Name  string
+
+	// The columns referencing the Model and the related Model
+	Key        string
+	ForeignKey string
+}
+
+// Names a statement run for an association
+func associationStmt(pAssociation, pStmt string) string {
+	return pAssociation + "." + pStmt
+}
+
 // TODO
 type Table struct {
 	Name string
@@ -0,0 +1,206 @@
+package opal
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// wideValues are the random values for every column of a wide
+type wideValues struct {
+	S [6]string
+	I [4]int64
+	F float64
+	B bool
+}
+
+func (o wideValues) Generate(pRand *rand.Rand, pSize int) reflect.Value {
+	var v wideValues
+	for i := range v.S {
+		v.S[i] = string(rune('a'+pRand.Intn(26))) + string(rune('a'+pRand.Intn(26)))
+	}
+	for i := range v.I {
+		v.I[i] = pRand.Int63()
+	}
+	v.F = pRand.Float64()
+	v.B = pRand.Intn(2) == 0
+	return reflect.ValueOf(v)
+}
+
+func (o wideValues) assign(pModel *wide) {
+	pModel.C0.A(o.S[0])
+	pModel.C1.A(o.I[0])
+	pModel.C2.A(o.S[1])
+	pModel.C3.A(o.F)
+	pModel.C4.A(o.S[2])
+	pModel.C5.A(o.I[1])
+	pModel.C6.A(o.B)
+	pModel.C7.A(o.S[3])
+	pModel.C8.A(o.I[2])
+	pModel.C9.A(o.S[4])
+	pModel.C10.A(o.I[3])
+	pModel.C11.A(o.S[5])
+}
+
+// Inserts, updates and re-reads wide Models to prove every bind
+// arg lines up with its column
+func TestModelMetadataBindOrder(t *testing.T) {
+	gem, _ := testGem(t, new(wide))
+	dao := &ModelIDAO{gem: gem, model: wideModel}
+	check := func(pInsert, pUpdate wideValues) bool {
+		m := gem.Bind(rawWide()).(*wide)
+		pInsert.assign(m)
+		if result := m.Insert(); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if found := dao.FindModel(m.Id.Primitive()); !reflect.DeepEqual(BindArgs(found), BindArgs(m)) {
+			t.Logf("inserted %v, found %v", m, found)
+			return false
+		}
+		pUpdate.assign(m)
+		if result := m.Save(); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		found := dao.FindModel(m.Id.Primitive())
+		if !reflect.DeepEqual(BindArgs(found), BindArgs(m)) {
+			t.Logf("updated %v, found %v", m, found)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// The fake database fails statements whose args do not match
+// their placeholders so the tests above would notice a mismatch
+func TestModelMetadataArgCount(t *testing.T) {
+	gem, _ := testGem(t, new(wide))
+	insert := gem.sqlBuilder(wideModel).Insert().Values().Sql()
+	args := insertArgs(gem.Bind(rawWide()))
+	for _, args := range [][]interface{}{args[1:], append(args, nil)} {
+		if _, err := gem.Exec(insert, args...); err == nil {
+			t.Errorf("Exec() with %d args for %q succeeded", len(args), insert)
+		}
+	}
+	if _, err := gem.Exec(insert, args...); err != nil {
+		t.Errorf("Exec() error = %v", err)
+	}
+}
+
+func TestModelMetadataColumnOrder(t *testing.T) {
+	var w wide
+	meta := NewMetadata(&w, reflect.TypeOf(w))
+	w.Gather(meta)
+	builder := &SqlBuilder{ModelMetadata: meta, Dialect: testDialect{}}
+	want := "UPDATE wides SET C0 = ?, C1 = ?, C2 = ?, C3 = ?, C4 = ?, C5 = ?, " +
+		"C6 = ?, C7 = ?, C8 = ?, C9 = ?, C10 = ?, C11 = ? WHERE Id = ?"
+	for i := 0; i < 10; i++ {
+		if s := builder.Update().WherePk().Sql().String(); s != want {
+			t.Fatalf("Update().WherePk() = %q, want %q", s, want)
+		}
+	}
+}
+
+func TestModelMetadataInsertableUpdatable(t *testing.T) {
+	gem, db := testGem(t, new(note))
+	m := gem.Bind(rawNote()).(*note)
+	m.Body.A("first")
+	m.Created.A("monday")
+	m.Computed.A("ignored")
+	if result := m.Insert(); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if n := db.count("INSERT INTO notes(Id, Body, Created) VALUES (?, ?, ?)"); n != 1 {
+		t.Errorf("insert statement ran %d times, want 1", n)
+	}
+	m.Body.A("second")
+	m.Created.A("tuesday")
+	if result := m.Save(); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if n := db.count("UPDATE notes SET Body = ? WHERE Id = ?"); n != 1 {
+		t.Errorf("update statement ran %d times, want 1", n)
+	}
+	dao := &ModelIDAO{gem: gem, model: noteModel}
+	found := dao.FindModel(m.Id.Primitive()).(*note)
+	if found.Body.String() != "second" || found.Created.String() != "monday" || found.Computed.Str != nil {
+		t.Errorf("found %v, want [%v second monday <nil>]", found, m.Id)
+	}
+}
+
+func TestModelMetadataGeneratedReadBack(t *testing.T) {
+	for _, dialect := range []Dialect{testDialect{}, returningDialect{}} {
+		gem, db := testDialectGem(t, dialect, new(ticket))
+		db.reset()
+		m := gem.Bind(rawTicket()).(*ticket)
+		m.Title.A("broken")
+		if result := m.Insert(); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if m.Id.Int64.Int64 == nil || m.Status.String() != "open" || m.Rank.Primitive() != 1 {
+			t.Errorf("%T: inserted %v, want generated values read back", dialect, m)
+		}
+		want := 2
+		if supportsReturning(dialect) {
+			want = 1
+		}
+		if n := len(db.executed); n != want {
+			t.Errorf("%T: insert ran %d statements %q, want %d", dialect, n, db.executed, want)
+		}
+	}
+}
+
+func TestSqlBuilderCreateDefault(t *testing.T) {
+	var m ticket
+	meta := NewMetadata(&m, reflect.TypeOf(m))
+	m.Gather(meta)
+	builder := &SqlBuilder{ModelMetadata: meta, Dialect: testDialect{}}
+	want := "CREATE TABLE IF NOT EXISTS tickets(Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, " +
+		"Title VARCHAR(255), Status VARCHAR(255) DEFAULT 'open', Rank INTEGER DEFAULT 1)"
+	if s := builder.Create().Sql().String(); s != want {
+		t.Errorf("Create() = %q, want %q", s, want)
+	}
+	want = "INSERT INTO tickets(Id, Title) VALUES (?, ?) RETURNING Id, Status, Rank"
+	if s := builder.Insert().Values().ReturningInserted().Sql().String(); s != want {
+		t.Errorf("Insert().Values().ReturningInserted() = %q, want %q", s, want)
+	}
+}
+
+func TestGemCreatesIndexes(t *testing.T) {
+	_, db := testGem(t, new(person), new(pet), new(toy))
+	if len(db.executed) < 2 || db.executed[1] != "CREATE INDEX IF NOT EXISTS people_by_name ON people(Name)" {
+		t.Errorf("executed %q, want the index created after the table", db.executed)
+	}
+}
+
+func TestGemCreatesReferencedTablesFirst(t *testing.T) {
+	gem, db := testGem(t, new(pet), new(person), new(toy))
+	want := "CREATE TABLE IF NOT EXISTS pets(Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, " +
+		"Name VARCHAR(255), OwnerId INTEGER, " +
+		"FOREIGN KEY (OwnerId) REFERENCES people (Id) ON DELETE CASCADE)"
+	if db.executed[0] != gem.sqlBuilder(personModel).Create().Sql().String() || db.executed[2] != want {
+		t.Errorf("executed %q, want people then %q", db.executed, want)
+	}
+	fks := gem.Metadata(petModel).ForeignKeys()
+	if len(fks) != 1 || fks[0] != (ForeignKey{"OwnerId", personModel, "people", "Id", "CASCADE", ""}) {
+		t.Errorf("ForeignKeys() = %v", fks)
+	}
+}
+
+func TestModelMetadataAssociations(t *testing.T) {
+	gem, _ := testGem(t, new(person), new(pet), new(toy))
+	pets, ok := gem.Metadata(personModel).Association("Pets")
+	if !ok || pets != (Association{Name: "Pets", Kind: HasMany, Model: petModel, ForeignKey: "OwnerId", Cascade: "save,delete"}) {
+		t.Errorf("Association(Pets) = %v, %v", pets, ok)
+	}
+	associations := gem.Metadata(petModel).Associations()
+	if len(associations) != 2 || associations[0].Kind != BelongsTo || associations[0].Model != personModel {
+		t.Errorf("Associations() = %v, want Owner first", associations)
+	}
+	if _, ok := gem.Metadata(personModel).Association("Owner"); ok {
+		t.Error("Association(Owner) found on the wrong side")
+	}
+}
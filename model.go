@@ -5,7 +5,12 @@
 package opal
 
 import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
 	"strings"
+	"time"
 )
 
 // Compile time check of Domain implementation
@@ -29,20 +34,15 @@ type Model interface {
 	// required to perform all database tasks associated
 	// with the Model
 
-	// It retrieves an address in which to store a Models domain
-	// Entity implementation. This implementation is required
-	// to perform basic database functions such as saving and
-	// deleting or other functions associated with a single Model
-	// instance.
-
-	// It also retrieves a function to create the Models domain
+	// It retrieves a function to create the Models domain
 	// specific DAO. This object can be used to perform tasks
 	// associated with the Models domain rather than a single instance.
-	Gather(pMetadata *ModelMetadata) (ModelName, *Entity, func(*ModelIDAO) ModelDAO)
+	// Each Gem makes its own DAO and Entity for the Model.
+	Gather(pMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO)
 
-	// ScanInto should return a new Model which can interact with
-	// the base DAO system and addresses to its columns so data
-	// can be scanned into it.
+	// ScanInto should return a new Model and addresses to its
+	// columns so data can be scanned into it. The Gem scanning
+	// it binds its Entity.
 	ScanInto() (Model, []interface{})
 
 	// Returns all columns' primary keys which can be scanned
@@ -79,6 +79,17 @@ func (m ModelName) String() string {
 // ModelHook function to run during Model related execution.
 type ModelHook func() error
 
+// Binds a hook which takes a context to the context of the call.
+// A hook is not run once the context is done.
+func contextHook(pCtx context.Context, fHook func(context.Context) error) ModelHook {
+	return func() error {
+		if err := pCtx.Err(); err != nil {
+			return err
+		}
+		return fHook(pCtx)
+	}
+}
+
 // Insert hooks ******************************************
 
 // Pre insert hook interface
@@ -91,14 +102,39 @@ type PostInsert interface {
 	PostInsertHook() error
 }
 
+// Pre insert hook interface given the context of the insert
+type PreInsertContext interface {
+	PreInsertHookContext(pCtx context.Context) error
+}
+
+// Post insert hook interface given the context of the insert
+type PostInsertContext interface {
+	PostInsertHookContext(pCtx context.Context) error
+}
+
+// After commit insert hook interface run once the insert is
+// committed rather than inside its transaction
+type AfterCommitInsert interface {
+	AfterCommitInsertHook()
+}
+
 // Gets the hook function from the model if they exist
-func insertHooks(pModel Model) (preHook ModelHook, postHook ModelHook) {
+func insertHooks(pCtx context.Context, pModel Model) (preHook ModelHook, postHook ModelHook, afterCommit func()) {
 	if hook, ok := pModel.(PreInsert); ok {
 		preHook = hook.PreInsertHook
 	}
+	if hook, ok := pModel.(PreInsertContext); ok {
+		preHook = contextHook(pCtx, hook.PreInsertHookContext)
+	}
 	if hook, ok := pModel.(PostInsert); ok {
 		postHook = hook.PostInsertHook
 	}
+	if hook, ok := pModel.(PostInsertContext); ok {
+		postHook = contextHook(pCtx, hook.PostInsertHookContext)
+	}
+	if hook, ok := pModel.(AfterCommitInsert); ok {
+		afterCommit = hook.AfterCommitInsertHook
+	}
 	return
 }
 
@@ -114,14 +150,39 @@ type PostUpdate interface {
 	PostUpdateHook() error
 }
 
+// Pre update hook interface given the context of the update
+type PreUpdateContext interface {
+	PreUpdateHookContext(pCtx context.Context) error
+}
+
+// Post update hook interface given the context of the update
+type PostUpdateContext interface {
+	PostUpdateHookContext(pCtx context.Context) error
+}
+
+// After commit update hook interface run once the update is
+// committed rather than inside its transaction
+type AfterCommitUpdate interface {
+	AfterCommitUpdateHook()
+}
+
 // Gets the hook function from the model if they exist
-func updateHooks(pModel Model) (preHook ModelHook, postHook ModelHook) {
+func updateHooks(pCtx context.Context, pModel Model) (preHook ModelHook, postHook ModelHook, afterCommit func()) {
 	if hook, ok := pModel.(PreUpdate); ok {
 		preHook = hook.PreUpdateHook
 	}
+	if hook, ok := pModel.(PreUpdateContext); ok {
+		preHook = contextHook(pCtx, hook.PreUpdateHookContext)
+	}
 	if hook, ok := pModel.(PostUpdate); ok {
 		postHook = hook.PostUpdateHook
 	}
+	if hook, ok := pModel.(PostUpdateContext); ok {
+		postHook = contextHook(pCtx, hook.PostUpdateHookContext)
+	}
+	if hook, ok := pModel.(AfterCommitUpdate); ok {
+		afterCommit = hook.AfterCommitUpdateHook
+	}
 	return
 }
 
@@ -137,14 +198,39 @@ type PostDelete interface {
 	PostDeleteHook() error
 }
 
+// Pre delete hook interface given the context of the delete
+type PreDeleteContext interface {
+	PreDeleteHookContext(pCtx context.Context) error
+}
+
+// Post delete hook interface given the context of the delete
+type PostDeleteContext interface {
+	PostDeleteHookContext(pCtx context.Context) error
+}
+
+// After commit delete hook interface run once the delete is
+// committed rather than inside its transaction
+type AfterCommitDelete interface {
+	AfterCommitDeleteHook()
+}
+
 // Gets the hook function from the model if they exist
-func deleteHooks(pModel Model) (preHook ModelHook, postHook ModelHook) {
+func deleteHooks(pCtx context.Context, pModel Model) (preHook ModelHook, postHook ModelHook, afterCommit func()) {
 	if hook, ok := pModel.(PreDelete); ok {
 		preHook = hook.PreDeleteHook
 	}
+	if hook, ok := pModel.(PreDeleteContext); ok {
+		preHook = contextHook(pCtx, hook.PreDeleteHookContext)
+	}
 	if hook, ok := pModel.(PostDelete); ok {
 		postHook = hook.PostDeleteHook
 	}
+	if hook, ok := pModel.(PostDeleteContext); ok {
+		postHook = contextHook(pCtx, hook.PostDeleteHookContext)
+	}
+	if hook, ok := pModel.(AfterCommitDelete); ok {
+		afterCommit = hook.AfterCommitDeleteHook
+	}
 	return
 }
 
@@ -166,14 +252,83 @@ func BindArgs(pModel Model) []interface{} {
 	return append(pModel.Keys(), pModel.Parameters()...)
 }
 
-// Gets the bind args required for a new Model
+// Gets the driver values of all the bind args of a Model.
+// Byte slices are copied so later scans cannot change them.
+func modelValues(pModel Model) []driver.Value {
+	args := BindArgs(pModel)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i], _ = arg.(driver.Valuer).Value()
+		if b, ok := values[i].([]byte); ok {
+			values[i] = append([]byte(nil), b...)
+		}
+	}
+	return values
+}
+
+// Sets all the bind args of a Model to the driver values
+func restoreValues(pModel Model, pValues []driver.Value) {
+	for i, arg := range BindArgs(pModel) {
+		setArg(arg, pValues[i])
+	}
+}
+
+// Compares two driver values
+func sameValue(a, b driver.Value) bool {
+	switch v := a.(type) {
+	case []byte:
+		w, ok := b.([]byte)
+		return ok && bytes.Equal(v, w)
+	case time.Time:
+		w, ok := b.(time.Time)
+		return ok && v.Equal(w)
+	}
+	return a == b
+}
+
+// Returned when a Model must be persisted before another
+// Model can reference it
+var ErrNotPersisted = errors.New("Opal: the Model has not been persisted")
+
+// Reports whether a Model has yet to be persisted which is when
+// it was neither found nor written so has no snapshot. Its keys
+// may be set as they are when supplied by the user. A Model
+// whose Entity keeps no snapshot is new when none of its keys
+// have a value.
+func IsNew(pModel Model) bool {
+	if field := entityField(pModel); field.IsValid() && field.IsNil() {
+		return true
+	}
+	if entity, ok := snapshotterOf(pModel); ok {
+		return entity.snapshotValues() == nil
+	}
+	for _, key := range pModel.Keys() {
+		if valuer, ok := key.(driver.Valuer); ok {
+			if value, _ := valuer.Value(); value != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Gets the bind args required for a new Model.
+// Columns which are not insertable are left out.
 func insertArgs(pModel Model) []interface{} {
-	return BindArgs(pModel)
+	meta := pModel.Metadata()
+	return filterArgs(BindArgs(pModel), meta.orderedColumns(), func(pColumn Column) bool {
+		return pColumn.Insertable
+	})
 }
 
-// Gets the bind args required to update the Model
+// Gets the bind args required to update the Model.
+// Columns which are not updatable are left out.
 func updateArgs(pModel Model) []interface{} {
-	return append(pModel.Parameters(), pModel.Keys()...)
+	meta := pModel.Metadata()
+	args := filterArgs(pModel.Parameters(), meta.NonKeys(), func(pColumn Column) bool {
+		return pColumn.Updatable
+	})
+	return append(args, pModel.Keys()...)
 }
 
 // Gets the bind args required to delete the Model
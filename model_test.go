@@ -0,0 +1,759 @@
+package opal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// The test Models are written the way entity.template generates
+// them so they exercise opal exactly as a domain would.
+
+type testBaseModel []Domain
+
+func (o testBaseModel) Models() []Domain {
+	return o
+}
+
+var testDatabases int64
+
+// Starts a Gem over a new fake database holding the Models
+func testGem(t *testing.T, pModels ...Domain) (*Gem, *fakeDB) {
+	return testDialectGem(t, testDialect{}, pModels...)
+}
+
+// Starts a Gem with the Dialect over a new fake database
+func testDialectGem(t *testing.T, pDialect Dialect, pModels ...Domain) (*Gem, *fakeDB) {
+	return testStartGem(t, StartArgs{BaseModel: testBaseModel(pModels), Dialect: pDialect})
+}
+
+// Starts a Gem with the args over a new fake database
+func testStartGem(t *testing.T, pArgs StartArgs) (*Gem, *fakeDB) {
+	name := fmt.Sprintf("%s_%d", t.Name(), atomic.AddInt64(&testDatabases, 1))
+	db, err := sql.Open("opaltest", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pArgs.DB = db
+	if pArgs.Dialect == nil {
+		pArgs.Dialect = testDialect{}
+	}
+	fake := testDriver.db(name)
+	fake.maxBindArgs = maxBindArgs(pArgs.Dialect)
+	return GEM(pArgs), fake
+}
+
+// *************************************************** WIDE
+
+const wideModel ModelName = "opal.wide"
+
+// wide has enough columns that map ordering would
+// be noticed when binding values
+type wide struct {
+	Entity
+	Id  AutoIncrement
+	C0  String
+	C1  Int64
+	C2  String
+	C3  Float64
+	C4  String
+	C5  Int64
+	C6  Bool
+	C7  String
+	C8  Int64
+	C9  String
+	C10 Int64
+	C11 String
+}
+
+func rawWide() *wide {
+	return new(wide)
+}
+
+func (wide) ScanInto() (Model, []interface{}) {
+	o := rawWide()
+	return o, BindArgs(o)
+}
+
+func (o *wide) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *wide) Parameters() []interface{} {
+	return []interface{}{&o.C0, &o.C1, &o.C2, &o.C3, &o.C4, &o.C5, &o.C6, &o.C7, &o.C8, &o.C9, &o.C10, &o.C11}
+}
+
+func (wide) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "wides"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	kinds := []reflect.Kind{reflect.String, reflect.Int64, reflect.String, reflect.Float64,
+		reflect.String, reflect.Int64, reflect.Bool, reflect.String, reflect.Int64,
+		reflect.String, reflect.Int64, reflect.String}
+	for i, kind := range kinds {
+		field := fmt.Sprintf("C%d", i)
+		pModelMetadata.AddColumn(field, i+2, Column{Name: field}, kind)
+	}
+	return wideModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** PERSON
+
+const personModel ModelName = "opal.person"
+
+type person struct {
+	Entity
+	Id   AutoIncrement
+	Name String `opal:"|Index: \"people_by_name\"|"`
+	pets []*pet
+}
+
+func rawPerson() *person {
+	return new(person)
+}
+
+func (person) ScanInto() (Model, []interface{}) {
+	o := rawPerson()
+	return o, BindArgs(o)
+}
+
+func (o *person) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *person) Parameters() []interface{} {
+	return []interface{}{&o.Name}
+}
+
+func (person) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "people"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Name", 2, Column{Name: "Name", Index: "people_by_name"}, reflect.String)
+	pModelMetadata.AddAssociation(Association{Name: "Pets", Kind: HasMany, Model: "opal.pet", ForeignKey: "OwnerId", Cascade: "save,delete"})
+	return personModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+func (o *person) Associate(pAssociation string, pModels []Model) {
+	switch pAssociation {
+	case "Pets":
+		o.pets = make([]*pet, len(pModels))
+		for i, model := range pModels {
+			o.pets[i] = model.(*pet)
+		}
+	}
+}
+
+func (o *person) Associated(pAssociation string) []Model {
+	switch pAssociation {
+	case "Pets":
+		if o.pets != nil {
+			models := make([]Model, len(o.pets))
+			for i, model := range o.pets {
+				models[i] = model
+			}
+			return models
+		}
+	}
+	return nil
+}
+
+// *************************************************** NOTE
+
+const noteModel ModelName = "opal.note"
+
+// note has a column which is only written on insert and one
+// which the database alone maintains
+type note struct {
+	Entity
+	Id       AutoIncrement
+	Body     String
+	Created  String `opal:"|Updatable: false|"`
+	Computed String `opal:"|Insertable: false, Updatable: false|"`
+}
+
+func rawNote() *note {
+	return new(note)
+}
+
+func (note) ScanInto() (Model, []interface{}) {
+	o := rawNote()
+	return o, BindArgs(o)
+}
+
+func (o *note) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *note) Parameters() []interface{} {
+	return []interface{}{&o.Body, &o.Created, &o.Computed}
+}
+
+func (note) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "notes"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Body", 2, Column{Name: "Body"}, reflect.String)
+	pModelMetadata.AddColumn("Created", 3, Column{Name: "Created", Updatable: false}, reflect.String)
+	pModelMetadata.AddColumn("Computed", 4, Column{Name: "Computed", Insertable: false, Updatable: false}, reflect.String)
+	return noteModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** TICKET
+
+const ticketModel ModelName = "opal.ticket"
+
+// ticket has columns which the database fills
+type ticket struct {
+	Entity
+	Id     AutoIncrement
+	Title  String
+	Status String `opal:"|Default: \"'open'\", Generated: true|"`
+	Rank   Int64  `opal:"|Default: \"1\", Generated: true|"`
+}
+
+func rawTicket() *ticket {
+	return new(ticket)
+}
+
+func (ticket) ScanInto() (Model, []interface{}) {
+	o := rawTicket()
+	return o, BindArgs(o)
+}
+
+func (o *ticket) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *ticket) Parameters() []interface{} {
+	return []interface{}{&o.Title, &o.Status, &o.Rank}
+}
+
+func (ticket) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "tickets"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Title", 2, Column{Name: "Title"}, reflect.String)
+	pModelMetadata.AddColumn("Status", 3, Column{Name: "Status", Default: "'open'", Generated: true}, reflect.String)
+	pModelMetadata.AddColumn("Rank", 4, Column{Name: "Rank", Default: "1", Generated: true}, reflect.Int64)
+	return ticketModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** ACCOUNT
+
+const accountModel ModelName = "opal.account"
+
+// account is versioned so lost updates are detected
+type account struct {
+	Entity
+	Id      AutoIncrement
+	Owner   String
+	Balance Int64
+	Version Version
+}
+
+func rawAccount() *account {
+	return new(account)
+}
+
+func (account) ScanInto() (Model, []interface{}) {
+	o := rawAccount()
+	return o, BindArgs(o)
+}
+
+func (o *account) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *account) Parameters() []interface{} {
+	return []interface{}{&o.Owner, &o.Balance, &o.Version}
+}
+
+func (account) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "accounts"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Owner", 2, Column{Name: "Owner"}, reflect.String)
+	pModelMetadata.AddColumn("Balance", 3, Column{Name: "Balance"}, reflect.Int64)
+	pModelMetadata.AddColumn("Version", 4, Column{Name: "Version", Version: true}, reflect.Int64)
+	return accountModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** POST
+
+const postModel ModelName = "opal.post"
+
+// post has timestamps which opal stamps and is soft deleted
+type post struct {
+	Entity
+	Id        AutoIncrement
+	Title     String
+	CreatedAt Time
+	UpdatedAt Time
+	DeletedAt Time
+}
+
+func rawPost() *post {
+	return new(post)
+}
+
+func (post) ScanInto() (Model, []interface{}) {
+	o := rawPost()
+	return o, BindArgs(o)
+}
+
+func (o *post) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *post) Parameters() []interface{} {
+	return []interface{}{&o.Title, &o.CreatedAt, &o.UpdatedAt, &o.DeletedAt}
+}
+
+func (post) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "posts"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Title", 2, Column{Name: "Title"}, reflect.String)
+	pModelMetadata.AddColumn("CreatedAt", 3, Column{Name: "CreatedAt", CreatedAt: true}, OpalTime)
+	pModelMetadata.AddColumn("UpdatedAt", 4, Column{Name: "UpdatedAt", UpdatedAt: true}, OpalTime)
+	pModelMetadata.AddColumn("DeletedAt", 5, Column{Name: "DeletedAt", DeletedAt: true}, OpalTime)
+	return postModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** THREAD
+
+const threadModel ModelName = "opal.thread"
+
+// thread deletes its replies which are soft deleted
+type thread struct {
+	Entity
+	Id    AutoIncrement
+	Title String
+}
+
+func rawThread() *thread {
+	return new(thread)
+}
+
+func (thread) ScanInto() (Model, []interface{}) {
+	o := rawThread()
+	return o, BindArgs(o)
+}
+
+func (o *thread) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *thread) Parameters() []interface{} {
+	return []interface{}{&o.Title}
+}
+
+func (thread) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "threads"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Title", 2, Column{Name: "Title"}, reflect.String)
+	pModelMetadata.AddAssociation(Association{Name: "Replies", Kind: HasMany, Model: "opal.reply", ForeignKey: "ThreadId", Cascade: "delete"})
+	return threadModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** REPLY
+
+const replyModel ModelName = "opal.reply"
+
+type reply struct {
+	Entity
+	Id        AutoIncrement
+	Body      String
+	ThreadId  Int64
+	DeletedAt Time
+}
+
+func rawReply() *reply {
+	return new(reply)
+}
+
+func (reply) ScanInto() (Model, []interface{}) {
+	o := rawReply()
+	return o, BindArgs(o)
+}
+
+func (o *reply) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *reply) Parameters() []interface{} {
+	return []interface{}{&o.Body, &o.ThreadId, &o.DeletedAt}
+}
+
+func (reply) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "replies"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Body", 2, Column{Name: "Body"}, reflect.String)
+	pModelMetadata.AddColumn("ThreadId", 3, Column{Name: "ThreadId", References: "opal.thread"}, reflect.Int64)
+	pModelMetadata.AddColumn("DeletedAt", 4, Column{Name: "DeletedAt", DeletedAt: true}, OpalTime)
+	return replyModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** SALE
+
+const saleModel ModelName = "opal.sale"
+
+// sale saves its lines which are keyed by the sale and their number
+type sale struct {
+	Entity
+	Id    AutoIncrement
+	Buyer String
+	lines []*saleLine
+}
+
+func rawSale() *sale {
+	return new(sale)
+}
+
+func (sale) ScanInto() (Model, []interface{}) {
+	o := rawSale()
+	return o, BindArgs(o)
+}
+
+func (o *sale) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *sale) Parameters() []interface{} {
+	return []interface{}{&o.Buyer}
+}
+
+func (sale) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "sales"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Buyer", 2, Column{Name: "Buyer"}, reflect.String)
+	pModelMetadata.AddAssociation(Association{Name: "Lines", Kind: HasMany, Model: "opal.saleLine", ForeignKey: "SaleId", Cascade: "save"})
+	return saleModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+func (o *sale) Associate(pAssociation string, pModels []Model) {
+	switch pAssociation {
+	case "Lines":
+		o.lines = make([]*saleLine, len(pModels))
+		for i, model := range pModels {
+			o.lines[i] = model.(*saleLine)
+		}
+	}
+}
+
+func (o *sale) Associated(pAssociation string) []Model {
+	switch pAssociation {
+	case "Lines":
+		if o.lines != nil {
+			models := make([]Model, len(o.lines))
+			for i, model := range o.lines {
+				models[i] = model
+			}
+			return models
+		}
+	}
+	return nil
+}
+
+// *************************************************** SALE LINE
+
+const saleLineModel ModelName = "opal.saleLine"
+
+type saleLine struct {
+	Entity
+	SaleId Int64
+	LineNo Int64
+	Item   String
+}
+
+func rawSaleLine() *saleLine {
+	return new(saleLine)
+}
+
+func (saleLine) ScanInto() (Model, []interface{}) {
+	o := rawSaleLine()
+	return o, BindArgs(o)
+}
+
+func (o *saleLine) Keys() []interface{} {
+	return []interface{}{&o.SaleId, &o.LineNo}
+}
+
+func (o *saleLine) Parameters() []interface{} {
+	return []interface{}{&o.Item}
+}
+
+func (saleLine) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "sale_lines"}, "SaleId", "LineNo")
+	pModelMetadata.AddKey("SaleId", 1, Column{Name: "SaleId", References: "opal.sale"}, reflect.Int64)
+	pModelMetadata.AddKey("LineNo", 2, Column{Name: "LineNo"}, reflect.Int64)
+	pModelMetadata.AddColumn("Item", 3, Column{Name: "Item"}, reflect.String)
+	return saleLineModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** INVOICE
+
+const invoiceModel ModelName = "opal.invoice"
+
+// invoice belongs to a tenant
+type invoice struct {
+	Entity
+	Id       AutoIncrement
+	TenantId Int64
+	Number   String
+}
+
+func rawInvoice() *invoice {
+	return new(invoice)
+}
+
+func (invoice) ScanInto() (Model, []interface{}) {
+	o := rawInvoice()
+	return o, BindArgs(o)
+}
+
+func (o *invoice) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *invoice) Parameters() []interface{} {
+	return []interface{}{&o.TenantId, &o.Number}
+}
+
+func (invoice) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "invoices"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("TenantId", 2, Column{Name: "TenantId"}, reflect.Int64)
+	pModelMetadata.AddColumn("Number", 3, Column{Name: "Number"}, reflect.String)
+	return invoiceModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** PET
+
+const petModel ModelName = "opal.pet"
+
+type pet struct {
+	Entity
+	Id      AutoIncrement
+	Name    String
+	OwnerId Int64
+	owner   *person `opal:"|OnDelete: \"CASCADE\"|"`
+	toys    []*toy
+}
+
+func rawPet() *pet {
+	return new(pet)
+}
+
+func (pet) ScanInto() (Model, []interface{}) {
+	o := rawPet()
+	return o, BindArgs(o)
+}
+
+func (o *pet) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *pet) Parameters() []interface{} {
+	return []interface{}{&o.Name, &o.OwnerId}
+}
+
+func (pet) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "pets"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Name", 2, Column{Name: "Name"}, reflect.String)
+	pModelMetadata.AddColumn("OwnerId", 3, Column{Name: "OwnerId", OnDelete: "CASCADE", References: "opal.person"}, reflect.Int64)
+	pModelMetadata.AddAssociation(Association{Name: "Owner", Kind: BelongsTo, Model: "opal.person", ForeignKey: "OwnerId"})
+	pModelMetadata.AddAssociation(Association{Name: "Toys", Kind: HasMany, Model: "opal.toy", ForeignKey: "PetId", Cascade: "save,nullify"})
+	return petModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+func (o *pet) Associate(pAssociation string, pModels []Model) {
+	switch pAssociation {
+	case "Owner":
+		o.owner = nil
+		if len(pModels) > 0 {
+			o.owner = pModels[0].(*person)
+		}
+	case "Toys":
+		o.toys = make([]*toy, len(pModels))
+		for i, model := range pModels {
+			o.toys[i] = model.(*toy)
+		}
+	}
+}
+
+func (o *pet) Associated(pAssociation string) []Model {
+	switch pAssociation {
+	case "Owner":
+		if o.owner != nil {
+			return []Model{o.owner}
+		}
+	case "Toys":
+		if o.toys != nil {
+			models := make([]Model, len(o.toys))
+			for i, model := range o.toys {
+				models[i] = model
+			}
+			return models
+		}
+	}
+	return nil
+}
+
+// Pets named undeletable fail their delete hook
+func (o *pet) PreDeleteHook() error {
+	if o.Name.String() == "undeletable" {
+		return errors.New("pet is undeletable")
+	}
+	return nil
+}
+
+// *************************************************** TOY
+
+const toyModel ModelName = "opal.toy"
+
+type toy struct {
+	Entity
+	Id    AutoIncrement
+	Name  String
+	PetId Int64 `opal:"|References: \"opal.pet\"|"`
+}
+
+func rawToy() *toy {
+	return new(toy)
+}
+
+func (toy) ScanInto() (Model, []interface{}) {
+	o := rawToy()
+	return o, BindArgs(o)
+}
+
+func (o *toy) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *toy) Parameters() []interface{} {
+	return []interface{}{&o.Name, &o.PetId}
+}
+
+func (toy) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "toys"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Name", 2, Column{Name: "Name"}, reflect.String)
+	pModelMetadata.AddColumn("PetId", 3, Column{Name: "PetId", References: "opal.pet"}, reflect.Int64)
+	return toyModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+// *************************************************** ARTICLE
+
+const articleModel ModelName = "opal.article"
+
+// article is linked to many tags through a join table
+type article struct {
+	Entity
+	Id    AutoIncrement
+	Title String
+	tags  []*tag
+}
+
+func rawArticle() *article {
+	return new(article)
+}
+
+func (article) ScanInto() (Model, []interface{}) {
+	o := rawArticle()
+	return o, BindArgs(o)
+}
+
+func (o *article) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *article) Parameters() []interface{} {
+	return []interface{}{&o.Title}
+}
+
+func (article) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "articles"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Title", 2, Column{Name: "Title"}, reflect.String)
+	pModelMetadata.AddAssociation(Association{Name: "Tags", Kind: ManyToMany, Model: "opal.tag", Through: JoinTable{Key: "ArticleId", ForeignKey: "TagId"}, Cascade: "save,delete"})
+	return articleModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+func (o *article) Associate(pAssociation string, pModels []Model) {
+	switch pAssociation {
+	case "Tags":
+		o.tags = make([]*tag, len(pModels))
+		for i, model := range pModels {
+			o.tags[i] = model.(*tag)
+		}
+	}
+}
+
+func (o *article) Associated(pAssociation string) []Model {
+	switch pAssociation {
+	case "Tags":
+		if o.tags != nil {
+			models := make([]Model, len(o.tags))
+			for i, model := range o.tags {
+				models[i] = model
+			}
+			return models
+		}
+	}
+	return nil
+}
+
+// *************************************************** TAG
+
+const tagModel ModelName = "opal.tag"
+
+type tag struct {
+	Entity
+	Id   AutoIncrement
+	Name String
+}
+
+func rawTag() *tag {
+	return new(tag)
+}
+
+func (tag) ScanInto() (Model, []interface{}) {
+	o := rawTag()
+	return o, BindArgs(o)
+}
+
+func (o *tag) Keys() []interface{} {
+	return []interface{}{&o.Id}
+}
+
+func (o *tag) Parameters() []interface{} {
+	return []interface{}{&o.Name}
+}
+
+func (tag) Gather(pModelMetadata *ModelMetadata) (ModelName, func(*ModelIDAO) ModelDAO) {
+	pModelMetadata.AddTable(Table{Name: "tags"}, "Id")
+	pModelMetadata.AddKey("Id", 1, Column{Name: "Id", AutoIncrement: true}, reflect.Int64)
+	pModelMetadata.AddColumn("Name", 2, Column{Name: "Name"}, reflect.String)
+	return tagModel, func(o *ModelIDAO) ModelDAO { return o }
+}
+
+func TestIsNew(t *testing.T) {
+	gem, _ := testGem(t, new(person), new(pet), new(toy))
+	people := &ModelIDAO{gem: gem, model: personModel}
+	o := rawPerson()
+	if !IsNew(o) {
+		t.Error("IsNew() = false before the key is set")
+	}
+	o.Id = *NewAutoIncrement(1)
+	if !IsNew(gem.Bind(o)) {
+		t.Error("IsNew() = false once the key is set but before it is inserted")
+	}
+	people.Insert(o)
+	if IsNew(o) {
+		t.Error("IsNew() = true after it is inserted")
+	}
+	if IsNew(people.FindModel(int64(1))) {
+		t.Error("IsNew() = true after it is found")
+	}
+}
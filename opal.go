@@ -5,11 +5,13 @@
 package opal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/twinj/version"
 	"log"
 	"reflect"
+	"time"
 )
 
 const (
@@ -26,27 +28,10 @@ const (
 	api       = 1
 )
 
-var (
-	// The current instance - instances can be swapped out
-	// TODO create a package only handle set by the user
-	currentGem *Gem
-)
-
 func init() {
 	//version.Init(group, opalMagic, release, iteration, revision, api, "OPAL")
 }
 
-// Copy a Gem into the packages address to be used as the current service
-func SwitchGem(pGem Gem) bool {
-	*currentGem = pGem
-	return true
-}
-
-// Retrieve a copy of Gem
-func GetGem() Gem {
-	return *currentGem
-}
-
 // ******************************************** Data Access
 
 // ActiveRecordDAO acts as a data provider for a Model's Entity.
@@ -64,6 +49,12 @@ type ActiveRecordDAO interface {
 	// Takes a Model and removes an existing entity from the
 	// data-store
 	Delete(pModel Model) Result
+
+	// The writes above run under a context which cancels them
+	// and is given to the Model's hooks
+	InsertContext(pCtx context.Context, pModel Model) Result
+	SaveContext(pCtx context.Context, pModel Model) Result
+	DeleteContext(pCtx context.Context, pModel Model) Result
 }
 
 // ActiveRecordDAO acts as a data provider for a Model's Entity.
@@ -89,11 +80,31 @@ type ModelDAO interface {
 	Opal
 	ActiveRecordDAO
 
+	// Removes the Model's entity from the data-store even
+	// when the Model is soft deleted
+	HardDelete(pModel Model) Result
+
 	// Find all models within the domain
-	FindAllModels() []Model
+	FindAllModels(pOptions ...FindOption) []Model
+	FindAllModelsContext(pCtx context.Context, pOptions ...FindOption) []Model
 
 	// Find a specific Model using its keys
 	FindModel(pKeys ...interface{}) Model
+	FindModelContext(pCtx context.Context, pKeys ...interface{}) Model
+
+	// Find a specific Model using its keys and options
+	FindModelWith(pKeys []interface{}, pOptions ...FindOption) Model
+
+	// Find all models whose field matches any of the values
+	FindAllModelsBy(pField string, pValues ...interface{}) []Model
+
+	// Find all models linked to the Model by a ManyToMany
+	FindAllLinked(pModel Model, pAssociation string) []Model
+
+	// Link or unlink the Model and related Models in a ManyToMany.
+	// Unlinking without related Models unlinks them all.
+	Link(pModel Model, pAssociation string, pRelated ...Model) Result
+	Unlink(pModel Model, pAssociation string, pRelated ...Model) Result
 
 	// Create a Sql Builder for the specified Model
 	SqlBuilder() *SqlBuilder
@@ -106,6 +117,24 @@ type ModelDAO interface {
 type ModelIDAO struct {
 	gem   *Gem
 	model ModelName
+
+	// The context values of scopes are drawn from
+	ctx context.Context
+}
+
+// WithContext gets a copy of the ModelIDAO whose calls draw
+// the values of scopes from the context
+func (o ModelIDAO) WithContext(pCtx context.Context) *ModelIDAO {
+	o.ctx = pCtx
+	return &o
+}
+
+// Gets the context of the ModelIDAO's calls
+func (o ModelIDAO) ExecorContext() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
 }
 
 func (o ModelIDAO) Model() ModelName {
@@ -123,15 +152,23 @@ func (ModelIDAO) Kind() reflect.Kind {
 }
 
 // TODO betterway to handle DAO
-func (o ModelIDAO) Gem() Gem {
-	return *o.gem
+func (o ModelIDAO) Gem() *Gem {
+	return o.gem
 }
 
-func (o ModelIDAO) FindAllModels() []Model {
+func (o ModelIDAO) FindAllModels(pOptions ...FindOption) []Model {
 	meta := o.gem.allModelsMetadata[o.Model()]
-	// TODO what if lose connection
-	stmt := meta.preparedStatements[findAll]
-	rows, err := stmt.Query()
+	options := newFindOptions(pOptions)
+	args, err := scopedArgs(o.ExecorContext(), o.Model(), meta.scopes, nil)
+	if err != nil {
+		log.Print(err)
+		return nil // TODO handle err
+	}
+	var rows *sql.Rows
+	err = o.readStmt(meta.scopedStmt(findAll, options.deleted), func(pStmt *sql.Stmt) (err error) {
+		rows, err = pStmt.QueryContext(o.ExecorContext(), args...)
+		return
+	})
 	if err != nil {
 		log.Print(err)
 		return nil // TODO handle err
@@ -141,90 +178,208 @@ func (o ModelIDAO) FindAllModels() []Model {
 	for rows.Next() {
 		model, args := meta.ScanInto()
 		rows.Scan(args...)
+		o.gem.Bind(model).Snapshot()
 		models = append(models, model)
 	}
+	if err := o.gem.preload(o.ExecorContext(), o.Model(), models, options.preload); err != nil {
+		log.Print(err)
+		return nil // TODO handle err
+	}
 	return models
 }
 
+// Finds all models as FindAllModels does under the context
+func (o ModelIDAO) FindAllModelsContext(pCtx context.Context, pOptions ...FindOption) []Model {
+	return o.WithContext(pCtx).FindAllModels(pOptions...)
+}
+
 // TODO better key solution
 func (o ModelIDAO) FindModel(pKeys ...interface{}) Model {
+	return o.FindModelWith(pKeys)
+}
+
+// Finds a Model as FindModel does under the context
+func (o ModelIDAO) FindModelContext(pCtx context.Context, pKeys ...interface{}) Model {
+	return o.WithContext(pCtx).FindModelWith(pKeys)
+}
+
+func (o ModelIDAO) FindModelWith(pKeys []interface{}, pOptions ...FindOption) Model {
 	meta := o.gem.allModelsMetadata[o.Model()]
-	stmt := meta.preparedStatements[find]
-	row := stmt.QueryRow(pKeys...)
-	model, args := meta.ScanInto()
-	err := row.Scan(args...)
+	options := newFindOptions(pOptions)
+	args, err := scopedArgs(o.ExecorContext(), o.Model(), meta.scopes, pKeys)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	model, dest := meta.ScanInto()
+	err = o.readStmt(meta.scopedStmt(find, options.deleted), func(pStmt *sql.Stmt) error {
+		return pStmt.QueryRowContext(o.ExecorContext(), args...).Scan(dest...)
+	})
 	if err != nil {
 		//TODO determine how errors should be handled
-		fmt.Println(err)
+		log.Print(err)
+		return nil
+	}
+	o.gem.Bind(model).Snapshot()
+	if err := o.gem.preload(o.ExecorContext(), o.Model(), []Model{model}, options.preload); err != nil {
+		log.Print(err)
 		return nil
 	}
 	return model
 }
 
-func (o *ModelIDAO) SqlBuilder() *SqlBuilder {
-	meta := o.gem.allModelsMetadata[o.Model()]
-	builder := new(SqlBuilder)
-	builder.ModelMetadata = &meta
-	builder.Dialect = o.gem.Dialect
-	return builder
+// Used by associations to find the Models which reference
+// one or more keys of another Model. Values beyond the bind
+// args the Dialect allows are found in batches.
+func (o ModelIDAO) FindAllModelsBy(pField string, pValues ...interface{}) []Model {
+	models, err := o.findAllModelsBy(withoutDeleted, pField, pValues...)
+	if err != nil {
+		return nil // TODO handle err
+	}
+	return models
 }
 
-// TODO find why insert prepared statement does not work
-// TODO fix persis
-// gets a primary key unique constraint error
-func (o *ModelIDAO) Insert(pModel Model) Result {
-	if o.gem.tx == nil {
-		// TODO remove?
-		builder := o.SqlBuilder().Insert().Values()
-		fPre, fPost := insertHooks(pModel)
-		if fPre != nil {
-			err := fPre()
-			if err != nil {
-				return Result{nil, err}
-			}
-		}
-		result, err := o.gem.Exec(builder, insertArgs(pModel)...)
+// Finds the Models by the values of a field including the soft
+// deleted ones within the scope
+func (o ModelIDAO) findAllModelsBy(pScope deletedScope, pField string, pValues ...interface{}) ([]Model, error) {
+	if len(pValues) == 0 {
+		return nil, nil
+	}
+	size := maxBindArgs(o.gem.Dialect) - len(o.gem.allModelsMetadata[o.Model()].scopes)
+	var models []Model
+	for _, values := range batches(pValues, size) {
+		builder := o.gem.sqlBuilder(o.Model())
+		found, err := o.gem.QueryContext(o.ExecorContext(), o.Model(), builder.Select().withScope(pScope).WhereIn(pField, len(values)).Sql(), values...)
 		if err != nil {
-			return Result{result, err}
-		}
-		// TODO dialect for Id
-		if id, err := result.LastInsertId(); err == nil {
-			// TODO compound key
-			v, ok := pModel.Keys()[0].(*AutoIncrement)
-			if ok {
-				v.A(id)
-			}
-			v2, ok := pModel.Keys()[0].(*Int64)
-			if ok {
-				v2.A(id)
-			}
+			return models, err
 		}
-		if fPost != nil {
-			err := fPost()
-			if err != nil {
-				return Result{nil, err}
-			}
+		models = append(models, found...)
+	}
+	return models, nil
+}
+
+func (o *ModelIDAO) FindAllLinked(pModel Model, pAssociation string) []Model {
+	association := o.manyToMany(pAssociation)
+	related := o.gem.allModelsMetadata[association.Model]
+	args, err := scopedArgs(o.ExecorContext(), association.Model, related.scopes, pModel.Keys())
+	if err != nil {
+		log.Print(err)
+		return nil // TODO handle err
+	}
+	var rows *sql.Rows
+	err = o.ExecorStmt(o.Model(), associationStmt(pAssociation, findAll), func(pStmt *sql.Stmt) (err error) {
+		rows, err = pStmt.QueryContext(o.ExecorContext(), args...)
+		return
+	})
+	if err != nil {
+		log.Print(err)
+		return nil // TODO handle err
+	}
+	defer rows.Close()
+	var models []Model
+	for rows.Next() {
+		model, args := related.ScanInto()
+		rows.Scan(args...)
+		o.gem.Bind(model).Snapshot()
+		models = append(models, model)
+	}
+	return models
+}
+
+func (o *ModelIDAO) Link(pModel Model, pAssociation string, pRelated ...Model) Result {
+	o.manyToMany(pAssociation)
+	return linkEach(o, pModel, associationStmt(pAssociation, link), pRelated)
+}
+
+func (o *ModelIDAO) Unlink(pModel Model, pAssociation string, pRelated ...Model) Result {
+	o.manyToMany(pAssociation)
+	if len(pRelated) == 0 {
+		return unlinkEach(o, pModel, pAssociation)
+	}
+	return linkEach(o, pModel, associationStmt(pAssociation, unlink), pRelated)
+}
+
+// Runs a join table statement for the Model and each related Model
+func linkEach(pExecor Execor, pModel Model, pNamedStmt string, pRelated []Model) (result Result) {
+	for _, related := range pRelated {
+		args := append(pModel.Keys(), related.Keys()...)
+		result.Error = pExecor.ExecorStmt(pModel.ModelName(), pNamedStmt, func(pStmt *sql.Stmt) (err error) {
+			result.Result, err = pStmt.ExecContext(pExecor.ExecorContext(), args...)
+			return
+		})
+		if result.Error != nil {
+			return
 		}
-		return Result{result, err}
 	}
-	return persist(o, pModel)
+	return
+}
+
+// Removes every link of the Model in a ManyToMany
+func unlinkEach(pExecor Execor, pModel Model, pAssociation string) (result Result) {
+	result.Error = pExecor.ExecorStmt(pModel.ModelName(), associationStmt(pAssociation, unlinkAll), func(pStmt *sql.Stmt) (err error) {
+		result.Result, err = pStmt.ExecContext(pExecor.ExecorContext(), pModel.Keys()...)
+		return
+	})
+	return
+}
+
+// Gets a ManyToMany association of the Model
+func (o *ModelIDAO) manyToMany(pAssociation string) Association {
+	association, ok := o.gem.allModelsMetadata[o.Model()].Association(pAssociation)
+	if !ok || association.Kind != ManyToMany {
+		panic(fmt.Sprintf("Opal.ModelIDAO: %s has no ManyToMany association %s", o.Model(), pAssociation))
+	}
+	return association
+}
+
+func (o *ModelIDAO) SqlBuilder() *SqlBuilder {
+	return o.gem.sqlBuilder(o.Model())
+}
+
+func (o *ModelIDAO) Insert(pModel Model) Result {
+	return o.gem.saveModel(o, pModel, insertModel)
 }
 
 func (o *ModelIDAO) Save(pModel Model) Result {
-	return merge(o, pModel)
+	return o.gem.saveModel(o, pModel, o.gem.merge)
 }
 
 func (o *ModelIDAO) Delete(pModel Model) Result {
-	return remove(o, pModel)
+	return o.gem.deleteModel(o, pModel, remove, withoutDeleted)
+}
+
+func (o *ModelIDAO) HardDelete(pModel Model) Result {
+	return o.gem.deleteModel(o, pModel, hardRemove, withDeleted)
+}
+
+func (o *ModelIDAO) InsertContext(pCtx context.Context, pModel Model) Result {
+	return o.WithContext(pCtx).Insert(pModel)
+}
+
+func (o *ModelIDAO) SaveContext(pCtx context.Context, pModel Model) Result {
+	return o.WithContext(pCtx).Save(pModel)
 }
 
-func (o *ModelIDAO) ExecorStmt(pModel ModelName, pNamedStmt string) *sql.Stmt {
-	// TODO handle disconnections
-	stmt := o.gem.allModelsMetadata[pModel].preparedStatements[pNamedStmt]
-	if o.gem.tx == nil {
-		return stmt
+func (o *ModelIDAO) DeleteContext(pCtx context.Context, pModel Model) Result {
+	return o.WithContext(pCtx).Delete(pModel)
+}
+
+// Runs the call with the statement inside the transaction of the
+// context if it has one
+func (o *ModelIDAO) ExecorStmt(pModel ModelName, pNamedStmt string, fRun func(*sql.Stmt) error) error {
+	if tx := txFromContext(o.ctx); tx != nil {
+		return tx.ExecorStmt(pModel, pNamedStmt, fRun)
+	}
+	return o.gem.runStmt(o.gem.DB, pModel, pNamedStmt, fRun)
+}
+
+// Runs the call with a statement which reads the Model. Outside a
+// transaction it runs on the pool the Gem routes the read to.
+func (o ModelIDAO) readStmt(pNamedStmt string, fRun func(*sql.Stmt) error) error {
+	if tx := txFromContext(o.ctx); tx != nil {
+		return tx.ExecorStmt(o.Model(), pNamedStmt, fRun)
 	}
-	return o.gem.tx.stmt(stmt)
+	return o.gem.runStmt(o.gem.reader(o.ExecorContext()), o.Model(), pNamedStmt, fRun)
 }
 
 // Future type for using when the opal sql has more of its own nuances
@@ -246,8 +401,25 @@ type StartArgs struct {
 	BaseModel    BaseModel
 	DB           *sql.DB
 	Dialect      Dialect
-	CreateEntity func(ModelName) Entity
+	CreateEntity func(*Gem, ModelName) Entity
 	Id           *OpalMagic
+
+	// Clock stamps the timestamp columns of Models and
+	// defaults to time.Now
+	Clock func() time.Time
+
+	// Scopes apply to every Model which has their field
+	Scopes []Scope
+
+	// Replicas serve the finds and queries which run outside a
+	// Transaction and a ReadYourWrites context. Route picks the
+	// replica of each and defaults to RoundRobin.
+	Replicas []*sql.DB
+	Route    ReplicaRoute
+
+	// The most ad-hoc statements, such as the updates of changed
+	// columns, kept prepared at once. Defaults to 100.
+	StmtCacheSize int
 }
 
 func GEM(o StartArgs) *Gem {
@@ -256,6 +428,12 @@ func GEM(o StartArgs) *Gem {
 	gem.Dialect = o.Dialect
 	gem.dao = &ModelIDAO{gem: gem}
 	gem.DB = o.DB
+	gem.stmts = newStmtCache(o.StmtCacheSize)
+	gem.replicas = o.Replicas
+	gem.route = o.Route
+	if gem.route == nil {
+		gem.route = RoundRobin()
+	}
 	gem.funcCreateDomainEntity = o.CreateEntity
 
 	SetMagic(o.Id)
@@ -264,9 +442,10 @@ func GEM(o StartArgs) *Gem {
 	}
 	models := o.BaseModel.Models()
 	gem.allModelsMetadata = make(map[ModelName]ModelMetadata, len(models))
-	gem.allModelsEntity = make(map[ModelName]*Entity, len(models))
-	gem.txPreparedStatements = make(map[*sql.Stmt]*sql.Stmt)
-	currentGem = gem
+	gem.allModelsEntity = make(map[ModelName]Entity, len(models))
+	gem.allModelsDAO = make(map[ModelName]ModelDAO, len(models))
+	gem.modelTypes = make(map[reflect.Type]ModelName, len(models))
+	metas := make(map[ModelName]*ModelMetadata, len(models))
 	for _, face := range models {
 		model, ok := face.(Model)
 		if !ok {
@@ -278,38 +457,142 @@ func GEM(o StartArgs) *Gem {
 		// Create the ModelMetadata and gather the
 		// table and column information
 		meta := NewMetadata(model, t)
+		meta.clock = o.Clock
 
 		// Gather the metadata and save into the ModelMetadata holder
-		name, entity, modelDAOf := model.Gather(meta) // TODO somehow detach Gather from model and initialise another way
+		name, modelDAOf := model.Gather(meta) // TODO somehow detach Gather from model and initialise another way
 
 		// Inject OpalDAOs into Model DAOs
 		// TODO report
-		modelDAO := modelDAOf(&ModelIDAO{gem, name})
+		modelDAO := modelDAOf(&ModelIDAO{gem: gem, model: name})
 
 		// Add the ModelName to the map for retrieving metadata
 		gem.modelNames = append(gem.modelNames, modelDAO.Model())
-		gem.allModelsMetadata[modelDAO.Model()] = *meta
-		gem.allModelsEntity[modelDAO.Model()] = entity
+		metas[modelDAO.Model()] = meta
+		gem.allModelsDAO[modelDAO.Model()] = modelDAO
+		gem.modelTypes[reflect.TypeOf(model)] = modelDAO.Model()
 
-		// Save an entity instance into the provided address
-		*gem.allModelsEntity[modelDAO.Model()] = gem.funcCreateDomainEntity(modelDAO.Model())
+		// The Gem's default Entity which its Models are bound to
+		gem.allModelsEntity[modelDAO.Model()] = gem.funcCreateDomainEntity(gem, modelDAO.Model())
+	}
+
+	// Foreign keys and associations reference the tables of other
+	// Models so are resolved once every Model has been gathered
+	for _, name := range gem.modelNames {
+		for _, scope := range o.Scopes {
+			if _, ok := metas[name].columnsByFieldName[scope.Field]; ok {
+				metas[name].AddScope(scope)
+			}
+		}
+		metas[name].resolveForeignKeys(metas)
+		metas[name].resolveAssociations(name, metas)
+		gem.allModelsMetadata[name] = *metas[name]
+	}
+
+	// Join tables Opal maintains are created along with the tables
+	// they reference though they are not Models
+	tables := append([]ModelName(nil), gem.modelNames...)
+	joins := make(map[ModelName]*ModelMetadata)
+	for _, name := range gem.modelNames {
+		for _, association := range metas[name].associations {
+			join := ModelName(association.Through.Name)
+			if association.Kind != ManyToMany || association.Through.Model != "" || joins[join] != nil {
+				continue
+			}
+			joins[join] = joinMetadata(association.Through, name, metas[name], association.Model, metas[association.Model])
+			joins[join].resolveForeignKeys(metas)
+			metas[join] = joins[join]
+			tables = append(tables, join)
+		}
+	}
+
+	for _, name := range tableOrder(tables, metas) {
+		meta := metas[name]
+		if _, ok := joins[name]; ok {
+			builder := &SqlBuilder{ModelMetadata: meta, Dialect: gem.Dialect}
+			gem.Exec(builder.Create().Sql())
+			continue
+		}
 
 		// Generate prepared statements
-		builder := modelDAO.SqlBuilder()
+		builder := gem.sqlBuilder(name)
 
 		// Create tables if necessary
 		table := builder.Create().Sql()
 		log.Printf("Opal.Start: Create table statement: %s", table.String())
 		gem.Exec(table)
+		for _, index := range meta.Indexes() {
+			gem.Exec(builder.CreateIndex(index).Sql())
+		}
+
+		// Statements are prepared on each pool on first use
+		meta.addStmt(findAll, builder.Select().Sql())
+		meta.addStmt(find, builder.Select().WherePk().Sql())
+		if _, ok := meta.deletedColumn(); ok {
+			for _, scope := range []deletedScope{withDeleted, onlyDeleted} {
+				meta.addStmt(meta.scopedStmt(findAll, scope), builder.Select().withScope(scope).Sql())
+				meta.addStmt(meta.scopedStmt(find, scope), builder.Select().WherePk().withScope(scope).Sql())
+			}
+			meta.addStmt(softDelete, builder.SoftDelete().WherePk().Sql())
+		}
+		meta.returning = supportsReturning(gem.Dialect)
+		if meta.returning && len(meta.insertReturnColumns()) > 0 {
+			meta.addStmt(insert, builder.Insert().Values().ReturningInserted().Sql())
+		} else {
+			meta.returning = false
+			meta.addStmt(insert, builder.Insert().Values().Sql())
+		}
+		if meta.returning && len(meta.generatedColumns()) > 0 {
+			meta.addStmt(update, builder.Update().WherePk().WhereVersion().ReturningGenerated().Sql())
+		} else {
+			meta.addStmt(update, builder.Update().WherePk().WhereVersion().Sql())
+		}
+		meta.addStmt(hardDelete, builder.Delete().WherePk().Sql())
+		gem.allModelsMetadata[name] = *meta
+	}
 
-		// Add these first run
-		meta.addStmt(gem.DB, findAll, builder.Select().Sql())
-		meta.addStmt(gem.DB, find, builder.Select().WherePk().Sql())
-		meta.addStmt(gem.DB, insert, builder.Insert().Values().Sql())
-		meta.addStmt(gem.DB, update, builder.Update().WherePk().Sql())
-		meta.addStmt(gem.DB, delete, builder.Delete().WherePk().Sql())
+	// Statements linking Models through join tables are prepared
+	// once every table exists
+	for _, name := range gem.modelNames {
+		meta := metas[name]
+		for _, association := range meta.associations {
+			if association.Kind != ManyToMany {
+				continue
+			}
+			join := association.Through
+			builder := gem.sqlBuilder(association.Model)
+			meta.addStmt(associationStmt(association.Name, findAll), builder.Select().WhereLinked(join).Sql())
+			meta.addStmt(associationStmt(association.Name, link), builder.Link(join).Sql())
+			meta.addStmt(associationStmt(association.Name, unlink), builder.Unlink(join).Sql())
+			meta.addStmt(associationStmt(association.Name, unlinkAll), builder.UnlinkAll(join).Sql())
+		}
+		gem.allModelsMetadata[name] = *meta
 	}
-	return currentGem
+	return gem
+}
+
+// Orders the Models so referenced tables are created before
+// the tables which reference them
+func tableOrder(pNames []ModelName, pMetas map[ModelName]*ModelMetadata) []ModelName {
+	order := make([]ModelName, 0, len(pNames))
+	visited := make(map[ModelName]bool, len(pNames))
+	var visit func(ModelName)
+	visit = func(pName ModelName) {
+		if visited[pName] {
+			return
+		}
+		visited[pName] = true
+		for _, fk := range pMetas[pName].foreignKeys {
+			if _, ok := pMetas[fk.References]; ok {
+				visit(fk.References)
+			}
+		}
+		order = append(order, pName)
+	}
+	for _, name := range pNames {
+		visit(name)
+	}
+	return order
 }
 
 // Base Model statement names
@@ -318,7 +601,19 @@ const (
 	findAll = "findAll"
 	insert  = "insert"
 	update  = "update"
-	delete  = "delete"
+
+	// Named apart from the builtin delete which it would hide
+	hardDelete = "delete"
+
+	// Models with a DeletedAt column only
+	softDelete = "softDelete"
+)
+
+// Statement names for the join tables of associations
+const (
+	link      = "link"
+	unlink    = "unlink"
+	unlinkAll = "unlinkAll"
 )
 
 type SqlBuilderDialectEncoder func(*SqlBuilder, DialectEncoder) *SqlBuilder
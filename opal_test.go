@@ -0,0 +1,377 @@
+package opal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindAllModelsBy(t *testing.T) {
+	gem, db := testGem(t, new(person), new(pet), new(toy))
+	people := &ModelIDAO{gem: gem, model: personModel}
+	pets := &ModelIDAO{gem: gem, model: petModel}
+	var owners []*person
+	for _, name := range []string{"Ann", "Bob", "Cat"} {
+		o := rawPerson()
+		o.Name = NewString(name)
+		if result := people.Insert(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		owners = append(owners, o)
+	}
+	for i, name := range []string{"Rex", "Tom", "Kit"} {
+		o := rawPet()
+		o.Name = NewString(name)
+		o.OwnerId = NewInt64(owners[i%2].Id.Primitive())
+		if result := pets.Insert(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	db.reset()
+	found := pets.FindAllModelsBy("OwnerId", owners[0].Id.Primitive())
+	if len(found) != 2 || found[0].(*pet).Name.String() != "Rex" || found[1].(*pet).Name.String() != "Kit" {
+		t.Errorf("FindAllModelsBy(Ann) = %v", found)
+	}
+	found = pets.FindAllModelsBy("OwnerId", owners[1].Id.Primitive(), owners[2].Id.Primitive())
+	if len(found) != 1 || found[0].(*pet).Name.String() != "Tom" {
+		t.Errorf("FindAllModelsBy(Bob, Cat) = %v", found)
+	}
+	want := []string{
+		"SELECT Id, Name, OwnerId FROM pets WHERE OwnerId = ?",
+		"SELECT Id, Name, OwnerId FROM pets WHERE OwnerId IN (?, ?)",
+	}
+	if len(db.executed) != 2 || db.executed[0] != want[0] || db.executed[1] != want[1] {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	if found := pets.FindAllModelsBy("OwnerId"); found != nil {
+		t.Errorf("FindAllModelsBy() = %v, want nil without values", found)
+	}
+}
+
+func TestGemCreatesJoinTables(t *testing.T) {
+	gem, db := testGem(t, new(article), new(tag))
+	want := "CREATE TABLE IF NOT EXISTS articles_tags(ArticleId INTEGER NOT NULL, TagId INTEGER NOT NULL, " +
+		"PRIMARY KEY (ArticleId, TagId), " +
+		"FOREIGN KEY (ArticleId) REFERENCES articles (Id) ON DELETE CASCADE, " +
+		"FOREIGN KEY (TagId) REFERENCES tags (Id) ON DELETE CASCADE)"
+	if len(db.executed) != 3 || db.executed[2] != want {
+		t.Errorf("executed %q, want the join table %q last", db.executed, want)
+	}
+	tags, _ := gem.Metadata(articleModel).Association("Tags")
+	if tags.Through != (JoinTable{Name: "articles_tags", Key: "ArticleId", ForeignKey: "TagId"}) {
+		t.Errorf("Through = %v", tags.Through)
+	}
+}
+
+func TestLink(t *testing.T) {
+	gem, _ := testGem(t, new(article), new(tag))
+	articles := &ModelIDAO{gem: gem, model: articleModel}
+	tags := &ModelIDAO{gem: gem, model: tagModel}
+	a := rawArticle()
+	a.Title = NewString("Opal")
+	articles.Insert(a)
+	var all []Model
+	for _, name := range []string{"go", "sql", "orm"} {
+		o := rawTag()
+		o.Name = NewString(name)
+		tags.Insert(o)
+		all = append(all, o)
+	}
+	linked := func(pDAO *ModelIDAO) (names []string) {
+		for _, model := range pDAO.FindAllLinked(a, "Tags") {
+			names = append(names, model.(*tag).Name.String())
+		}
+		return
+	}
+	names := func() []string { return linked(articles) }
+
+	if result := articles.Link(a, "Tags", all...); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if got := names(); !reflect.DeepEqual(got, []string{"go", "sql", "orm"}) {
+		t.Errorf("FindAllLinked() = %v after linking all", got)
+	}
+	if result := articles.Unlink(a, "Tags", all[1]); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if got := names(); !reflect.DeepEqual(got, []string{"go", "orm"}) {
+		t.Errorf("FindAllLinked() = %v after unlinking sql", got)
+	}
+
+	// Links made inside a failed transaction are rolled back
+	gem.Begin(func(pTx Transaction) Result {
+		inTx := articles.WithContext(pTx.Context())
+		inTx.Link(a, "Tags", all[1])
+		if got := linked(inTx); len(got) != 3 {
+			t.Errorf("FindAllLinked() = %v inside the transaction", got)
+		}
+		return Result{Error: errors.New("rollback")}
+	}).Go()
+	if got := names(); !reflect.DeepEqual(got, []string{"go", "orm"}) {
+		t.Errorf("FindAllLinked() = %v after rolling back", got)
+	}
+
+	if result := articles.Unlink(a, "Tags"); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if got := names(); len(got) != 0 {
+		t.Errorf("FindAllLinked() = %v after unlinking all", got)
+	}
+}
+
+func TestDirtyTracking(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	o := gem.Bind(rawWide()).(*wide)
+	if !o.IsDirty() || len(o.DirtyFields()) != 13 {
+		t.Errorf("DirtyFields() = %v, want every field before a snapshot", o.DirtyFields())
+	}
+	o.C0 = NewString("a")
+	wides.Insert(o)
+	if o.IsDirty() {
+		t.Errorf("DirtyFields() = %v after insert", o.DirtyFields())
+	}
+
+	o = wides.FindModel(o.Id.Primitive()).(*wide)
+	o.C2 = NewString("b")
+	o.C5 = NewInt64(5)
+	if got := o.DirtyFields(); !reflect.DeepEqual(got, []string{"C2", "C5"}) {
+		t.Errorf("DirtyFields() = %v, want [C2 C5]", got)
+	}
+	db.reset()
+	if result := wides.Save(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	want := "UPDATE wides SET C2 = ?, C5 = ? WHERE Id = ?"
+	if len(db.executed) != 1 || db.executed[0] != want {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	if found := wides.FindModel(o.Id.Primitive()).(*wide); found.C0.String() != "a" || found.C2.String() != "b" || found.C5.Primitive() != 5 {
+		t.Errorf("found %v after the update", found)
+	}
+
+	// Unchanged Models are not written
+	db.reset()
+	if result := wides.Save(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if len(db.executed) != 0 {
+		t.Errorf("executed %q, want nothing for an unchanged Model", db.executed)
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	gem, db := testGem(t, new(account))
+	accounts := &ModelIDAO{gem: gem, model: accountModel}
+	o := rawAccount()
+	o.Owner = NewString("Ann")
+	o.Balance = NewInt64(10)
+	accounts.Insert(o)
+	if o.Version.Primitive() != 1 {
+		t.Errorf("Version = %d after insert, want 1", o.Version.Primitive())
+	}
+
+	mine := accounts.FindModel(o.Id.Primitive()).(*account)
+	theirs := accounts.FindModel(o.Id.Primitive()).(*account)
+	mine.Balance = NewInt64(20)
+	db.reset()
+	if result := accounts.Save(mine); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	want := "UPDATE accounts SET Balance = ?, Version = ? WHERE Id = ? AND Version = ?"
+	if len(db.executed) != 1 || db.executed[0] != want {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	if mine.Version.Primitive() != 2 {
+		t.Errorf("Version = %d after update, want 2", mine.Version.Primitive())
+	}
+
+	// The second writer read the first version so loses
+	theirs.Owner = NewString("Bob")
+	result := accounts.Save(theirs)
+	stale, ok := result.Error.(*ErrStaleModel)
+	if !ok || stale.ModelName != accountModel || !reflect.DeepEqual(stale.Key, []interface{}{o.Id.Primitive()}) {
+		t.Fatalf("Save() error = %#v, want ErrStaleModel", result.Error)
+	}
+	if theirs.Version.Primitive() != 1 || !theirs.IsDirty() {
+		t.Errorf("Version = %d, dirty %v, want the stale Model kept", theirs.Version.Primitive(), theirs.IsDirty())
+	}
+	found := accounts.FindModel(o.Id.Primitive()).(*account)
+	if found.Owner.String() != "Ann" || found.Balance.Primitive() != 20 || found.Version.Primitive() != 2 {
+		t.Errorf("found %v, want the first update only", found)
+	}
+
+	// Full updates are versioned too
+	found.Owner = NewString("Cat")
+	found.Balance = NewInt64(30)
+	if result := accounts.Save(found); result.Error != nil || found.Version.Primitive() != 3 {
+		t.Errorf("Save() = %v with version %d, want version 3", result.Error, found.Version.Primitive())
+	}
+
+	// A rolled back update leaves the version as it was
+	found.Balance = NewInt64(40)
+	gem.Begin(func(pTx Transaction) Result {
+		accounts.WithContext(pTx.Context()).Save(found)
+		return Result{Error: errors.New("rollback")}
+	}).Go()
+	if found.Version.Primitive() != 3 {
+		t.Errorf("Version = %d after a rollback, want 3", found.Version.Primitive())
+	}
+	if result := accounts.Save(found); result.Error != nil || found.Version.Primitive() != 4 {
+		t.Errorf("Save() after a rollback = %v with version %d, want version 4", result.Error, found.Version.Primitive())
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	now := time.Date(2014, 3, 12, 18, 34, 0, 0, time.UTC)
+	gem, db := testStartGem(t, StartArgs{
+		BaseModel: testBaseModel{new(post)},
+		Clock: func() time.Time {
+			now = now.Add(time.Minute)
+			return now
+		},
+	})
+	posts := &ModelIDAO{gem: gem, model: postModel}
+	o := rawPost()
+	o.Title = NewString("Opal")
+	posts.Insert(o)
+	created := time.Date(2014, 3, 12, 18, 35, 0, 0, time.UTC)
+	if !o.CreatedAt.Equal(created) || !o.UpdatedAt.Equal(created) {
+		t.Errorf("stamped %v and %v on insert, want %v", o.CreatedAt, o.UpdatedAt, created)
+	}
+
+	found := posts.FindModel(o.Id.Primitive()).(*post)
+	found.Title = NewString("Opal ORM")
+	db.reset()
+	posts.Save(found)
+	want := "UPDATE posts SET Title = ?, UpdatedAt = ? WHERE Id = ?"
+	if len(db.executed) != 1 || db.executed[0] != want {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	found = posts.FindModel(o.Id.Primitive()).(*post)
+	if !found.CreatedAt.Equal(created) || !found.UpdatedAt.Equal(created.Add(time.Minute)) {
+		t.Errorf("stamped %v and %v on update", found.CreatedAt, found.UpdatedAt)
+	}
+
+	// A creation time which is set is kept
+	o = rawPost()
+	o.CreatedAt = NewTime(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	posts.Insert(o)
+	if o.CreatedAt.Year() != 2000 {
+		t.Errorf("CreatedAt = %v, want it kept", o.CreatedAt)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	gem, db := testGem(t, new(post))
+	posts := &ModelIDAO{gem: gem, model: postModel}
+	var all []*post
+	for _, title := range []string{"kept", "deleted", "removed"} {
+		o := rawPost()
+		o.Title = NewString(title)
+		posts.Insert(o)
+		all = append(all, o)
+	}
+	titles := func(pModels []Model) (titles []string) {
+		for _, model := range pModels {
+			titles = append(titles, model.(*post).Title.String())
+		}
+		return
+	}
+
+	db.reset()
+	if result := posts.Delete(all[1]); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	want := "UPDATE posts SET DeletedAt = ? WHERE Id = ?"
+	if len(db.executed) != 1 || db.executed[0] != want || all[1].DeletedAt.Time == nil {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	if result := posts.HardDelete(all[2]); result.Error != nil || rows(db, "posts") != 2 {
+		t.Errorf("HardDelete() = %v leaving %d rows, want the row removed", result.Error, rows(db, "posts"))
+	}
+
+	if got := titles(posts.FindAllModels()); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("FindAllModels() = %v", got)
+	}
+	if got := titles(posts.FindAllModels(WithDeleted())); !reflect.DeepEqual(got, []string{"kept", "deleted"}) {
+		t.Errorf("FindAllModels(WithDeleted()) = %v", got)
+	}
+	if got := titles(posts.FindAllModels(OnlyDeleted())); !reflect.DeepEqual(got, []string{"deleted"}) {
+		t.Errorf("FindAllModels(OnlyDeleted()) = %v", got)
+	}
+	if found := posts.FindModel(all[1].Id.Primitive()); found != nil {
+		t.Errorf("FindModel() = %v, want the deleted post left out", found)
+	}
+	if found := posts.FindModelWith([]interface{}{all[1].Id.Primitive()}, WithDeleted()); found == nil {
+		t.Error("FindModelWith(WithDeleted()) found nothing")
+	}
+	if got := titles(posts.FindAllModelsBy("Title", "kept", "deleted")); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("FindAllModelsBy() = %v", got)
+	}
+
+	// Queries built with the builder are scoped too
+	builder := posts.SqlBuilder()
+	models, _ := gem.Query(postModel, builder.Select().Sql())
+	if got := titles(models); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("Query() = %v", got)
+	}
+	models, _ = gem.Query(postModel, builder.Select().WithDeleted().Sql())
+	if got := titles(models); !reflect.DeepEqual(got, []string{"kept", "deleted"}) {
+		t.Errorf("Query(WithDeleted) = %v", got)
+	}
+	if sql := builder.Select().WherePk().OnlyDeleted().Sql().String(); sql != "SELECT Id, Title, CreatedAt, UpdatedAt, DeletedAt FROM posts WHERE Id = ? AND DeletedAt IS NOT NULL" {
+		t.Errorf("Sql() = %s", sql)
+	}
+}
+
+// hookedWide records the context its insert hook is given
+type hookedWide struct {
+	*wide
+	ctx context.Context
+}
+
+func (o *hookedWide) PreInsertHookContext(pCtx context.Context) error {
+	o.ctx = pCtx
+	return nil
+}
+
+func TestContext(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	wides.Insert(rawWide())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db.reset()
+	if result := wides.InsertContext(ctx, rawWide()); result.Error != context.Canceled {
+		t.Errorf("InsertContext() error = %v, want %v", result.Error, context.Canceled)
+	}
+	if models := wides.FindAllModelsContext(ctx); models != nil {
+		t.Errorf("FindAllModelsContext() = %v under a cancelled context", models)
+	}
+	if model := wides.FindModelContext(ctx, NewAutoIncrement(1)); model != nil {
+		t.Errorf("FindModelContext() = %v under a cancelled context", model)
+	}
+	if _, ok := gem.BeginContext(ctx, func(pTx Transaction) Result {
+		return Result{}
+	}).Go(); ok {
+		t.Error("Go() succeeded under a cancelled context")
+	}
+	if len(db.executed) != 0 {
+		t.Errorf("executed %q under a cancelled context", db.executed)
+	}
+
+	hooked := &hookedWide{wide: rawWide()}
+	want := context.WithValue(context.Background(), tenantKey{}, "hooked")
+	fPre, _, _ := insertHooks(want, hooked)
+	if err := fPre(); err != nil || hooked.ctx != want {
+		t.Errorf("PreInsertHookContext() given %v, want %v", hooked.ctx, want)
+	}
+	if err := contextHook(ctx, hooked.PreInsertHookContext)(); err != context.Canceled {
+		t.Errorf("hook error = %v under a cancelled context", err)
+	}
+}
@@ -0,0 +1,44 @@
+package opal
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+)
+
+// ReplicaRoute picks the replica a read runs on from the number
+// of replicas the Gem has. An index outside them runs the read
+// on the primary.
+type ReplicaRoute func(pCtx context.Context, pReplicas int) int
+
+// RoundRobin makes a ReplicaRoute which spreads reads across
+// the replicas in turn. It is the default route of a Gem.
+func RoundRobin() ReplicaRoute {
+	var next uint32
+	return func(_ context.Context, pReplicas int) int {
+		return int((atomic.AddUint32(&next, 1) - 1) % uint32(pReplicas))
+	}
+}
+
+type primaryKey struct{}
+
+// ReadYourWrites gets a context whose reads run on the primary
+// so they see the writes made before them. Reads inside a
+// Transaction always do.
+func ReadYourWrites(pCtx context.Context) context.Context {
+	return context.WithValue(pCtx, primaryKey{}, true)
+}
+
+// Gets the connection pool a read under the context runs on
+func (o *Gem) reader(pCtx context.Context) *sql.DB {
+	if len(o.replicas) == 0 || pCtx == nil {
+		return o.DB
+	}
+	if primary, _ := pCtx.Value(primaryKey{}).(bool); primary {
+		return o.DB
+	}
+	if i := o.route(pCtx, len(o.replicas)); i >= 0 && i < len(o.replicas) {
+		return o.replicas[i]
+	}
+	return o.DB
+}
@@ -0,0 +1,81 @@
+package opal
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestReplicas(t *testing.T) {
+	replica, replicaDB := testGem(t, new(wide))
+	(&ModelIDAO{gem: replica, model: wideModel}).Insert(rawWide())
+
+	gem, db := testStartGem(t, StartArgs{
+		BaseModel: testBaseModel{new(wide)},
+		Replicas:  []*sql.DB{replica.DB},
+	})
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	for i := 0; i < 2; i++ {
+		if result := wides.Insert(rawWide()); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	// Reads run on the replica and writes on the primary
+	db.reset()
+	replicaDB.reset()
+	if found := wides.FindAllModels(); len(found) != 1 {
+		t.Errorf("FindAllModels() found %d, want the replica's 1", len(found))
+	}
+	if found := wides.FindModel(int64(2)); found != nil {
+		t.Errorf("FindModel() = %v, want it read from the replica", found)
+	}
+	if found, _ := gem.Query(wideModel, gem.sqlBuilder(wideModel).Select().Sql()); len(found) != 1 {
+		t.Errorf("Query() found %d, want the replica's 1", len(found))
+	}
+	if len(db.executed) != 0 || replicaDB.count("SELECT") != 3 {
+		t.Errorf("executed %q on the primary and %q on the replica", db.executed, replicaDB.executed)
+	}
+
+	// Reads which must see the writes run on the primary
+	primary := wides.WithContext(ReadYourWrites(context.Background()))
+	if found := primary.FindAllModels(); len(found) != 2 {
+		t.Errorf("ReadYourWrites FindAllModels() found %d, want 2", len(found))
+	}
+	if found := primary.FindModel(int64(2)); found == nil {
+		t.Error("ReadYourWrites FindModel() found nothing")
+	}
+	gem.Begin(func(pTx Transaction) Result {
+		if found := wides.WithContext(pTx.Context()).FindAllModels(); len(found) != 2 {
+			t.Errorf("FindAllModels() in a transaction found %d, want 2", len(found))
+		}
+		return Result{}
+	}).Go()
+	if replicaDB.count("SELECT") != 3 {
+		t.Errorf("executed %q on the replica", replicaDB.executed)
+	}
+}
+
+func TestReplicaRoute(t *testing.T) {
+	route := RoundRobin()
+	for i, want := range []int{0, 1, 2, 0, 1} {
+		if got := route(context.Background(), 3); got != want {
+			t.Errorf("read %d routed to %d, want %d", i, got, want)
+		}
+	}
+
+	replica, replicaDB := testGem(t, new(wide))
+	gem, db := testStartGem(t, StartArgs{
+		BaseModel: testBaseModel{new(wide)},
+		Replicas:  []*sql.DB{replica.DB},
+		Route: func(context.Context, int) int {
+			return -1
+		},
+	})
+	db.reset()
+	replicaDB.reset()
+	(&ModelIDAO{gem: gem, model: wideModel}).FindAllModels()
+	if db.count("SELECT") != 1 || len(replicaDB.executed) != 0 {
+		t.Errorf("executed %q on the primary and %q on the replica, want a primary read", db.executed, replicaDB.executed)
+	}
+}
@@ -0,0 +1,69 @@
+package opal
+
+import (
+	"context"
+	"fmt"
+)
+
+// Scope is a condition ANDed into every select, update and delete
+// of a Model which has its field so a statement can never reach
+// rows outside it. The value the field must hold is drawn from the
+// context of the call e.g. the tenant of a request. Inserts set the
+// field to the value and updates never change it.
+type Scope struct {
+	Field string
+
+	// Gets the value from the context or nil when it has none
+	Value func(context.Context) interface{}
+}
+
+// ErrMissingScope is the error of a call whose context does not
+// hold the value of one of the Model's scopes. The statement is
+// not run.
+type ErrMissingScope struct {
+	ModelName ModelName
+	Field     string
+}
+
+func (o *ErrMissingScope) Error() string {
+	return fmt.Sprintf("Opal: the context has no %s for %s", o.Field, o.ModelName)
+}
+
+// scopedSql is the Sql of a statement which binds the values
+// of its Model's scopes after any other args
+type scopedSql struct {
+	OpalSql
+	scopes []Scope
+}
+
+// Appends the values of the scopes drawn from the context to the args
+func scopedArgs(pCtx context.Context, pModelName ModelName, pScopes []Scope, pArgs []interface{}) ([]interface{}, error) {
+	if len(pScopes) == 0 {
+		return pArgs, nil
+	}
+	if pCtx == nil {
+		pCtx = context.Background()
+	}
+	args := append([]interface{}(nil), pArgs...)
+	for _, scope := range pScopes {
+		value := scope.Value(pCtx)
+		if value == nil {
+			return nil, &ErrMissingScope{pModelName, scope.Field}
+		}
+		args = append(args, value)
+	}
+	return args, nil
+}
+
+// Sets the scoped fields of a Model being inserted
+func scopeInsert(pCtx context.Context, pModel Model) error {
+	meta := pModel.Metadata()
+	values, err := scopedArgs(pCtx, pModel.ModelName(), meta.scopes, nil)
+	if err != nil {
+		return err
+	}
+	for i, scope := range meta.scopes {
+		setField(pModel, meta, scope.Field, values[i])
+	}
+	return nil
+}
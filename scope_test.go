@@ -0,0 +1,86 @@
+package opal
+
+import (
+	"context"
+	"testing"
+)
+
+type tenantKey struct{}
+
+// Scopes Models with a TenantId by the tenant of the context
+var tenantScope = Scope{
+	Field: "TenantId",
+	Value: func(pCtx context.Context) interface{} {
+		return pCtx.Value(tenantKey{})
+	},
+}
+
+func tenant(pId int64) context.Context {
+	return context.WithValue(context.Background(), tenantKey{}, pId)
+}
+
+func TestScopes(t *testing.T) {
+	gem, db := testStartGem(t, StartArgs{
+		BaseModel: testBaseModel{new(invoice), new(wide)},
+		Scopes:    []Scope{tenantScope},
+	})
+	invoices := &ModelIDAO{gem: gem, model: invoiceModel}
+	ann, bob := invoices.WithContext(tenant(1)), invoices.WithContext(tenant(2))
+	for _, dao := range []*ModelIDAO{ann, ann, bob} {
+		o := rawInvoice()
+		o.Number = NewString("INV")
+		o.TenantId = NewInt64(3)
+		if result := dao.Insert(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if len(ann.FindAllModels()) != 2 || len(bob.FindAllModels()) != 1 {
+		t.Errorf("found %d and %d invoices, want each tenant's own", len(ann.FindAllModels()), len(bob.FindAllModels()))
+	}
+	if found := ann.FindModel(int64(3)); found != nil {
+		t.Errorf("FindModel() = %v, want another tenant's invoice hidden", found)
+	}
+	if found := ann.FindAllModelsBy("Number", "INV"); len(found) != 2 {
+		t.Errorf("FindAllModelsBy() found %d, want 2", len(found))
+	}
+
+	// Writes cannot reach another tenant's rows
+	theirs := bob.FindModel(int64(3)).(*invoice)
+	theirs.Number = NewString("changed")
+	db.reset()
+	ann.Save(theirs)
+	ann.Delete(theirs)
+	want := []string{
+		"UPDATE invoices SET Number = ? WHERE Id = ? AND TenantId = ?",
+		"DELETE FROM invoices WHERE Id = ? AND TenantId = ?",
+	}
+	if len(db.executed) != 2 || db.executed[0] != want[0] || db.executed[1] != want[1] {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+	if found := bob.FindModel(int64(3)).(*invoice); found.Number.String() != "INV" {
+		t.Errorf("found %v, want the invoice untouched", found)
+	}
+
+	// Queries from the builder bind the scope
+	models, err := gem.QueryContext(tenant(2), invoiceModel, invoices.SqlBuilder().Select().Sql())
+	if err != nil || len(models) != 1 {
+		t.Errorf("QueryContext() = %v, %v", models, err)
+	}
+
+	// Without a tenant nothing runs
+	result := invoices.Insert(rawInvoice())
+	if missing, ok := result.Error.(*ErrMissingScope); !ok || missing.Field != "TenantId" {
+		t.Errorf("Insert() error = %v, want ErrMissingScope", result.Error)
+	}
+	if _, err := gem.Query(invoiceModel, invoices.SqlBuilder().Select().Sql()); err == nil {
+		t.Error("Query() ran without a tenant")
+	}
+	if found := invoices.FindAllModels(); found != nil {
+		t.Errorf("FindAllModels() = %v without a tenant", found)
+	}
+
+	// Models without the field are not scoped
+	if result := (&ModelIDAO{gem: gem, model: wideModel}).Insert(rawWide()); result.Error != nil {
+		t.Error(result.Error)
+	}
+}
@@ -0,0 +1,260 @@
+package opal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ShardFunc picks the shard, from the number of shards, which
+// holds the Model with the value of its shard key
+type ShardFunc func(pModelName ModelName, pValue interface{}, pShards int) int
+
+// HashShard spreads the values of shard keys evenly across the
+// shards. It is the default ShardFunc of a ShardedGem.
+func HashShard(_ ModelName, pValue interface{}, pShards int) int {
+	h := fnv.New32a()
+	fmt.Fprint(h, pValue)
+	return int(h.Sum32() % uint32(pShards))
+}
+
+// ErrCrossShard is the error of a call under the context of a
+// Transaction which would reach another shard. A Transaction
+// runs on one Gem only.
+var ErrCrossShard = errors.New("Opal: a Transaction cannot span shards")
+
+type ShardArgs struct {
+	// The Gems of the shards which each hold the same Models
+	Gems []*Gem
+
+	// Shard picks the Gem of a shard key and defaults to HashShard
+	Shard ShardFunc
+
+	// The field each Model is sharded by. Models without one
+	// are sharded by their first key.
+	Fields map[ModelName]string
+}
+
+// ShardedGem routes the calls for a Model to the Gem of the
+// shard which holds it. Finds which cannot be routed run on
+// every shard and their Models are merged in shard order.
+// The Models are bound to the Gem they were found or written
+// through so their ActiveRecord calls stay on their shard.
+type ShardedGem struct {
+	gems   []*Gem
+	shard  ShardFunc
+	fields map[ModelName]string
+}
+
+func NewShardedGem(pArgs ShardArgs) *ShardedGem {
+	if len(pArgs.Gems) == 0 {
+		panic("Opal.ShardedGem: there must be at least one Gem")
+	}
+	o := new(ShardedGem)
+	o.gems = pArgs.Gems
+	o.shard = pArgs.Shard
+	if o.shard == nil {
+		o.shard = HashShard
+	}
+	o.fields = pArgs.Fields
+	return o
+}
+
+// Gets the Gems of the shards in order
+func (o *ShardedGem) Gems() []*Gem {
+	return o.gems
+}
+
+// Close closes the Gem of every shard returning the first error
+func (o *ShardedGem) Close() error {
+	var err error
+	for _, gem := range o.gems {
+		if e := gem.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Gets the Gem of the shard holding the value of the
+// Model's shard key
+func (o *ShardedGem) Shard(pModelName ModelName, pValue interface{}) *Gem {
+	return o.gems[o.shard(pModelName, shardValue(pValue), len(o.gems))]
+}
+
+// Gets the Gem of the shard which holds the Model
+func (o *ShardedGem) ShardOf(pModel Model) (*Gem, error) {
+	name := o.gems[0].modelName(pModel)
+	value := fieldValue(pModel, o.gems[0].Metadata(name), o.shardField(name))
+	if value == nil {
+		return nil, fmt.Errorf("Opal.ShardedGem: %s has no value to shard it by", name)
+	}
+	return o.Shard(name, value), nil
+}
+
+// Gets the field the Model is sharded by
+func (o *ShardedGem) shardField(pModelName ModelName) string {
+	if field, ok := o.fields[pModelName]; ok {
+		return field
+	}
+	keys := o.gems[0].Metadata(pModelName).Keys()
+	if len(keys) == 0 {
+		panic(fmt.Sprintf("Opal.ShardedGem: %s is not a Model of the Gems", pModelName))
+	}
+	return keys[0].Identifier
+}
+
+// Gets the Gem a call under the context runs on. A context
+// bound to a Transaction on another shard fails.
+func (o *ShardedGem) within(pCtx context.Context, pGem *Gem) (*Gem, error) {
+	if tx := txFromContext(pCtx); tx != nil && tx.gem != pGem {
+		return nil, ErrCrossShard
+	}
+	return pGem, nil
+}
+
+// Converts the value of a shard key to the driver value a
+// Model holds so both shard alike
+func shardValue(pValue interface{}) interface{} {
+	if value, err := driver.DefaultParameterConverter.ConvertValue(pValue); err == nil {
+		return value
+	}
+	return pValue
+}
+
+// ******************************************** Writes
+
+func (o *ShardedGem) Insert(pModel Model) Result {
+	return o.InsertContext(context.Background(), pModel)
+}
+
+func (o *ShardedGem) Save(pModel Model) Result {
+	return o.SaveContext(context.Background(), pModel)
+}
+
+func (o *ShardedGem) Delete(pModel Model) Result {
+	return o.DeleteContext(context.Background(), pModel)
+}
+
+func (o *ShardedGem) InsertContext(pCtx context.Context, pModel Model) Result {
+	return o.write(pCtx, pModel, ActiveRecordDAO.InsertContext)
+}
+
+func (o *ShardedGem) SaveContext(pCtx context.Context, pModel Model) Result {
+	return o.write(pCtx, pModel, ActiveRecordDAO.SaveContext)
+}
+
+func (o *ShardedGem) DeleteContext(pCtx context.Context, pModel Model) Result {
+	return o.write(pCtx, pModel, ActiveRecordDAO.DeleteContext)
+}
+
+// Runs a write on the shard of the Model binding it there first.
+// A Model bound to another shard loses its snapshot so all its
+// columns are written.
+func (o *ShardedGem) write(pCtx context.Context, pModel Model, fWrite func(ActiveRecordDAO, context.Context, Model) Result) Result {
+	gem, err := o.ShardOf(pModel)
+	if err == nil {
+		gem, err = o.within(pCtx, gem)
+	}
+	if err != nil {
+		return Result{Error: err}
+	}
+	if entity := entityField(pModel); entity.IsValid() && (entity.IsNil() || pModel.Gem() != gem) {
+		gem.Bind(pModel)
+	}
+	return fWrite(gem.DAO(gem.modelName(pModel)), pCtx, pModel)
+}
+
+// ******************************************** Finds
+
+// Finds a Model by its keys on its shard. A Model sharded by
+// another field is looked for on every shard.
+func (o *ShardedGem) FindModel(pModelName ModelName, pKeys ...interface{}) (Model, error) {
+	return o.FindModelContext(context.Background(), pModelName, pKeys...)
+}
+
+func (o *ShardedGem) FindModelContext(pCtx context.Context, pModelName ModelName, pKeys ...interface{}) (Model, error) {
+	keys := o.gems[0].Metadata(pModelName).Keys()
+	if len(keys) > 0 && len(pKeys) > 0 && o.shardField(pModelName) == keys[0].Identifier {
+		gem, err := o.within(pCtx, o.Shard(pModelName, pKeys[0]))
+		if err != nil {
+			return nil, err
+		}
+		return gem.DAO(pModelName).FindModelContext(pCtx, pKeys...), nil
+	}
+	models, err := o.fanOut(pCtx, func(pGem *Gem) ([]Model, error) {
+		if model := pGem.DAO(pModelName).FindModelContext(pCtx, pKeys...); model != nil {
+			return []Model{model}, nil
+		}
+		return nil, nil
+	})
+	if err != nil || len(models) == 0 {
+		return nil, err
+	}
+	return models[0], nil
+}
+
+// Finds all the Models on every shard
+func (o *ShardedGem) FindAllModels(pModelName ModelName, pOptions ...FindOption) ([]Model, error) {
+	return o.FindAllModelsContext(context.Background(), pModelName, pOptions...)
+}
+
+func (o *ShardedGem) FindAllModelsContext(pCtx context.Context, pModelName ModelName, pOptions ...FindOption) ([]Model, error) {
+	return o.fanOut(pCtx, func(pGem *Gem) ([]Model, error) {
+		return pGem.DAO(pModelName).FindAllModelsContext(pCtx, pOptions...), nil
+	})
+}
+
+// Runs a query on every shard
+func (o *ShardedGem) Query(pModelName ModelName, pSql Sql, pArgs ...interface{}) ([]Model, error) {
+	return o.QueryContext(context.Background(), pModelName, pSql, pArgs...)
+}
+
+func (o *ShardedGem) QueryContext(pCtx context.Context, pModelName ModelName, pSql Sql, pArgs ...interface{}) ([]Model, error) {
+	return o.fanOut(pCtx, func(pGem *Gem) ([]Model, error) {
+		return pGem.QueryContext(pCtx, pModelName, pSql, pArgs...)
+	})
+}
+
+// Runs the find on every shard at once merging the Models in
+// shard order. It cannot run within a Transaction.
+func (o *ShardedGem) fanOut(pCtx context.Context, fFind func(*Gem) ([]Model, error)) ([]Model, error) {
+	if tx := txFromContext(pCtx); tx != nil && (len(o.gems) > 1 || tx.gem != o.gems[0]) {
+		return nil, ErrCrossShard
+	}
+	found := make([][]Model, len(o.gems))
+	errs := make([]error, len(o.gems))
+	var wg sync.WaitGroup
+	for i, gem := range o.gems {
+		wg.Add(1)
+		go func(i int, pGem *Gem) {
+			defer wg.Done()
+			found[i], errs[i] = fFind(pGem)
+		}(i, gem)
+	}
+	wg.Wait()
+	var models []Model
+	for i := range o.gems {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		models = append(models, found[i]...)
+	}
+	return models, nil
+}
+
+// ******************************************** Transactions
+
+// Begin makes a new Transaction on the shard holding the value
+// of the Model's shard key. Calls through the ShardedGem under
+// its Context which reach another shard fail with ErrCrossShard.
+func (o *ShardedGem) Begin(pModelName ModelName, pValue interface{}, fAction Action, pOptions ...TxOption) *Txn {
+	return o.BeginContext(context.Background(), pModelName, pValue, fAction, pOptions...)
+}
+
+func (o *ShardedGem) BeginContext(pCtx context.Context, pModelName ModelName, pValue interface{}, fAction Action, pOptions ...TxOption) *Txn {
+	return o.Shard(pModelName, pValue).BeginContext(pCtx, fAction, pOptions...)
+}
@@ -0,0 +1,96 @@
+package opal
+
+import (
+	"testing"
+)
+
+// Shards integer keys by their remainder
+func modShard(_ ModelName, pValue interface{}, pShards int) int {
+	return int(pValue.(int64) % int64(pShards))
+}
+
+func shardedWide(pId int64) *wide {
+	o := rawWide()
+	o.Id.A(pId)
+	return o
+}
+
+func TestShardedGem(t *testing.T) {
+	even, evenDB := testGem(t, new(wide), new(invoice))
+	odd, oddDB := testGem(t, new(wide), new(invoice))
+	shards := NewShardedGem(ShardArgs{
+		Gems:   []*Gem{even, odd},
+		Shard:  modShard,
+		Fields: map[ModelName]string{invoiceModel: "TenantId"},
+	})
+
+	// Models are written to the shard of their key or field
+	for _, id := range []int64{1, 2, 3} {
+		if result := shards.Insert(shardedWide(id)); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		o := rawInvoice()
+		o.TenantId = NewInt64(id)
+		if result := shards.Insert(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if result := shards.Insert(rawWide()); result.Error == nil {
+		t.Error("Insert() of a Model without a shard key succeeded")
+	}
+	for gem, want := range map[*Gem]int{even: 1, odd: 2} {
+		for _, name := range []ModelName{wideModel, invoiceModel} {
+			if found := gem.DAO(name).FindAllModels(); len(found) != want {
+				t.Errorf("shard holds %d of %s, want %d", len(found), name, want)
+			}
+		}
+	}
+
+	// Finds by key run on one shard and others on all of them
+	evenDB.reset()
+	oddDB.reset()
+	found, err := shards.FindModel(wideModel, int64(3))
+	if err != nil || found == nil || found.Gem() != odd {
+		t.Fatalf("FindModel() = %v, %v, want the Model bound to its shard", found, err)
+	}
+	if len(evenDB.executed) != 0 {
+		t.Errorf("executed %q on another shard", evenDB.executed)
+	}
+	all, err := shards.FindAllModels(invoiceModel)
+	if err != nil || len(all) != 3 || all[0].Gem() != even {
+		t.Errorf("FindAllModels() = %v, %v, want 3 merged in shard order", all, err)
+	}
+	queried, err := shards.Query(wideModel, even.sqlBuilder(wideModel).Select().Sql())
+	if err != nil || len(queried) != 3 {
+		t.Errorf("Query() = %v, %v, want 3", queried, err)
+	}
+
+	// ActiveRecord calls stay on the shard
+	oddDB.reset()
+	found.(*wide).C0 = NewString("changed")
+	if result := found.Save(); result.Error != nil || oddDB.count("UPDATE") != 1 {
+		t.Errorf("Save() = %v executing %q on the shard", result, oddDB.executed)
+	}
+
+	// Transactions run on one shard only
+	_, ok := shards.Begin(wideModel, int64(5), func(pTx Transaction) Result {
+		if result := shards.InsertContext(pTx.Context(), shardedWide(4)); result.Error != ErrCrossShard {
+			t.Errorf("InsertContext() on another shard error = %v, want ErrCrossShard", result.Error)
+		}
+		if _, err := shards.FindAllModelsContext(pTx.Context(), wideModel); err != ErrCrossShard {
+			t.Errorf("FindAllModelsContext() error = %v, want ErrCrossShard", err)
+		}
+		if result, _ := even.BeginContext(pTx.Context(), func(Transaction) Result {
+			return Result{}
+		}).Go(); result.Error != ErrCrossShard {
+			t.Errorf("nested Go() on another shard error = %v, want ErrCrossShard", result.Error)
+		}
+		return shards.InsertContext(pTx.Context(), shardedWide(5))
+	}).Go()
+	if !ok {
+		t.Error("Go() failed")
+	}
+	if found, _ := shards.FindModel(wideModel, int64(5)); found == nil || found.Gem() != odd {
+		t.Errorf("FindModel() = %v, want the Model inserted in the transaction", found)
+	}
+}
@@ -0,0 +1,199 @@
+package opal
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// The number of ad-hoc statements a Gem keeps prepared by default
+const defaultStmtCacheSize = 100
+
+// ErrGemClosed is the error of a statement run after its Gem closed
+var ErrGemClosed = errors.New("Opal: the Gem is closed")
+
+// Identifies a named statement of a Model on a connection pool
+type stmtKey struct {
+	db   *sql.DB
+	name string
+}
+
+// stmtCache holds the statements a Gem prepared on each of its
+// connection pools. The named statements of its Models are kept
+// until the Gem closes. Ad-hoc statements such as the updates of
+// changed columns are kept up to a bound, the least recently used
+// closed first.
+type stmtCache struct {
+	mu     sync.Mutex
+	size   int
+	closed bool
+	named  map[stmtKey]*sql.Stmt
+	adHoc  map[stmtKey]*list.Element
+
+	// The ad-hoc statements most recently used first
+	order *list.List
+}
+
+type adHocStmt struct {
+	key  stmtKey
+	stmt *sql.Stmt
+}
+
+func newStmtCache(pSize int) *stmtCache {
+	o := new(stmtCache)
+	o.size = pSize
+	if o.size <= 0 {
+		o.size = defaultStmtCacheSize
+	}
+	o.named = make(map[stmtKey]*sql.Stmt)
+	o.adHoc = make(map[stmtKey]*list.Element)
+	o.order = list.New()
+	return o
+}
+
+// Gets the statement preparing it with the func if it has not been.
+// The lock is not held while preparing so others are not held up.
+func (o *stmtCache) get(pKey stmtKey, pAdHoc bool, fPrepare func() (*sql.Stmt, error)) (*sql.Stmt, error) {
+	if stmt, err := o.lookup(pKey, pAdHoc); stmt != nil || err != nil {
+		return stmt, err
+	}
+	stmt, err := fPrepare()
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		stmt.Close()
+		return nil, ErrGemClosed
+	}
+	var evicted []*sql.Stmt
+	if held := o.held(pKey, pAdHoc); held != nil {
+		// Prepared by another at the same time
+		evicted = append(evicted, stmt)
+		stmt = held
+	} else if pAdHoc {
+		o.adHoc[pKey] = o.order.PushFront(&adHocStmt{pKey, stmt})
+		for o.order.Len() > o.size {
+			evicted = append(evicted, o.remove(o.order.Back()))
+		}
+	} else {
+		o.named[pKey] = stmt
+	}
+	o.mu.Unlock()
+	closeAll(evicted)
+	return stmt, nil
+}
+
+// Looks up a statement marking an ad-hoc one as used
+func (o *stmtCache) lookup(pKey stmtKey, pAdHoc bool) (*sql.Stmt, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil, ErrGemClosed
+	}
+	return o.held(pKey, pAdHoc), nil
+}
+
+// Gets a held statement. The lock must be held.
+func (o *stmtCache) held(pKey stmtKey, pAdHoc bool) *sql.Stmt {
+	if !pAdHoc {
+		return o.named[pKey]
+	}
+	if e, ok := o.adHoc[pKey]; ok {
+		o.order.MoveToFront(e)
+		return e.Value.(*adHocStmt).stmt
+	}
+	return nil
+}
+
+// Removes an ad-hoc statement returning it to be closed. The
+// lock must be held.
+func (o *stmtCache) remove(pElement *list.Element) *sql.Stmt {
+	held := o.order.Remove(pElement).(*adHocStmt)
+	delete(o.adHoc, held.key)
+	return held.stmt
+}
+
+// Forgets and closes a statement which went stale so the next
+// get prepares it again. A statement already replaced is left.
+func (o *stmtCache) stale(pKey stmtKey, pStmt *sql.Stmt) {
+	o.mu.Lock()
+	if o.named[pKey] == pStmt {
+		delete(o.named, pKey)
+	}
+	if e, ok := o.adHoc[pKey]; ok && e.Value.(*adHocStmt).stmt == pStmt {
+		o.remove(e)
+	}
+	o.mu.Unlock()
+	pStmt.Close()
+}
+
+// Closes every statement. Those asked for after fail.
+func (o *stmtCache) close() error {
+	o.mu.Lock()
+	var stmts []*sql.Stmt
+	for _, stmt := range o.named {
+		stmts = append(stmts, stmt)
+	}
+	for o.order.Len() > 0 {
+		stmts = append(stmts, o.remove(o.order.Back()))
+	}
+	o.named = make(map[stmtKey]*sql.Stmt)
+	o.closed = true
+	o.mu.Unlock()
+	return closeAll(stmts)
+}
+
+// Closes the statements returning the first error
+func closeAll(pStmts []*sql.Stmt) (err error) {
+	for _, stmt := range pStmts {
+		if e := stmt.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Gets the sql of a named statement of the Model and whether
+// it is ad-hoc
+func (o *Gem) stmtSql(pModelName ModelName, pNamedStmt string) (string, bool, error) {
+	if query, ok := o.allModelsMetadata[pModelName].statements[pNamedStmt]; ok {
+		return query, false, nil
+	}
+	if query, ok := o.adHocSql(pModelName, pNamedStmt); ok {
+		return query, true, nil
+	}
+	return "", false, fmt.Errorf("Opal.Gem: %s has no statement %s", pModelName, pNamedStmt)
+}
+
+// Gets a named statement of the Model prepared on the pool. It is
+// prepared on its first use.
+func (o *Gem) prepared(pDB *sql.DB, pModelName ModelName, pNamedStmt string) (*sql.Stmt, error) {
+	query, adHoc, err := o.stmtSql(pModelName, pNamedStmt)
+	if err != nil {
+		return nil, err
+	}
+	return o.stmts.get(stmtKey{pDB, pModelName.String() + "." + pNamedStmt}, adHoc, func() (*sql.Stmt, error) {
+		return pDB.Prepare(query)
+	})
+}
+
+// Runs the call with a named statement of the Model on the pool.
+// Should it fail because the statement went stale the statement is
+// prepared again and the call run once more.
+func (o *Gem) runStmt(pDB *sql.DB, pModelName ModelName, pNamedStmt string, fRun func(*sql.Stmt) error) error {
+	for retried := false; ; retried = true {
+		stmt, err := o.prepared(pDB, pModelName, pNamedStmt)
+		if err != nil {
+			return err
+		}
+		err = fRun(stmt)
+		if err == nil || retried || !isStale(o.Dialect, err) {
+			return err
+		}
+		o.stmts.stale(stmtKey{pDB, pModelName.String() + "." + pNamedStmt}, stmt)
+	}
+}
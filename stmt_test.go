@@ -0,0 +1,107 @@
+package opal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLazyStatements(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	if n := db.preparedCount("SELECT"); n != 0 {
+		t.Errorf("prepared %d selects at start, want none until used", n)
+	}
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	wides.Insert(rawWide())
+	db.reset()
+	for i := 0; i < 3; i++ {
+		wides.FindAllModels()
+	}
+	if n := db.preparedCount("SELECT"); n != 1 {
+		t.Errorf("prepared %q, want the select once", db.prepared)
+	}
+
+	// A stale statement is prepared again and the call retried
+	db.changeSchema()
+	db.reset()
+	if found := wides.FindAllModels(); len(found) != 1 {
+		t.Errorf("FindAllModels() after the schema changed found %d, want 1", len(found))
+	}
+	if found := wides.FindModel(int64(1)); found == nil {
+		t.Error("FindModel() after the schema changed found nothing")
+	}
+	if result := wides.Insert(rawWide()); result.Error != nil {
+		t.Errorf("Insert() after the schema changed error = %v", result.Error)
+	}
+	if n := db.preparedCount("SELECT"); n != 2 || db.closed != 2 {
+		t.Errorf("prepared %q closing %d, want each stale statement replaced", db.prepared, db.closed)
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("sql: statement is closed"), true},
+		{errors.New("database schema has changed"), true},
+		{fmt.Errorf("cached plan must not change result type: %w", sqlStateError("0A000")), true},
+		{sqlStateError("0A000"), false},
+		{errors.New("constraint failed"), false},
+	} {
+		if got := isStale(testDialect{}, test.err); got != test.want {
+			t.Errorf("isStale(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestStmtCacheSize(t *testing.T) {
+	gem, db := testStartGem(t, StartArgs{BaseModel: testBaseModel{new(wide)}, StmtCacheSize: 1})
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	o := rawWide()
+	wides.Insert(o)
+	db.reset()
+	for _, value := range []string{"a", "b", "c"} {
+		o.C0 = NewString(value)
+		if result := wides.Save(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		o.C2 = NewString(value)
+		if result := wides.Save(o); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+	if gem.stmts.order.Len() != 1 || len(gem.stmts.adHoc) != 1 || db.closed != 5 {
+		t.Errorf("holding %d ad-hoc statements having closed %d, want 1 holding 5 closed", gem.stmts.order.Len(), db.closed)
+	}
+	if found := wides.FindModel(int64(1)).(*wide); found.C0.String() != "c" || found.C2.String() != "c" {
+		t.Errorf("FindModel() = %v, want every update made", found)
+	}
+
+	// The sql of an evicted update is built again from its name
+	want := "UPDATE wides SET C0 = ? WHERE Id = ?"
+	if query, adHoc, err := gem.stmtSql(wideModel, partialUpdate([]string{"C0"})); query != want || !adHoc || err != nil {
+		t.Errorf("stmtSql() = %q, %v, %v, want %q", query, adHoc, err, want)
+	}
+}
+
+func TestGemClose(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	wides.Insert(rawWide())
+	wides.FindAllModels()
+	db.reset()
+	if err := gem.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db.closed != 2 {
+		t.Errorf("closed %d statements, want the insert and select", db.closed)
+	}
+	if result := wides.Insert(rawWide()); result.Error != ErrGemClosed {
+		t.Errorf("Insert() after Close error = %v, want ErrGemClosed", result.Error)
+	}
+	if err := gem.DB.Ping(); err != nil {
+		t.Errorf("Ping() after Close error = %v, want the DB left open", err)
+	}
+}
@@ -1,11 +1,21 @@
 package opal
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Txn is a sql.Tx wrapper which holds the action to run and its result
 // It also carries a reference to the current Gem.
+// A Txn is only joined by the calls made through it or through a DAO
+// given its Context so any number can run at once.
+// A Txn begun under the Context of another runs nested within it
+// under a savepoint.
 type Txn struct {
 
 	//Embedded so Txn behaves like a standard Tx
@@ -23,6 +33,43 @@ type Txn struct {
 	// to provide user simplicity in writing their own exec stmt
 	// and transactions
 	result Result
+
+	// The context the values of scopes are drawn from
+	ctx context.Context
+
+	// How the Tx is begun and retried
+	options *txOptions
+
+	// The Gem's named statements prepared on the Tx
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// The Txn a nested Txn runs within and the count of
+	// savepoints set in the outermost Txn
+	parent     *Txn
+	savepoints int32
+
+	// Funcs run once the Transaction commits or rolls back
+	callbackMu    sync.Mutex
+	afterCommit   []func()
+	afterRollback []func()
+
+	// The state of each Model before its first write so a
+	// rollback can put it back
+	journalMu sync.Mutex
+	journal   []modelState
+	journaled map[interface{}]bool
+}
+
+type txKey struct{}
+
+// Gets the Txn a context is bound to if any
+func txFromContext(pCtx context.Context) *Txn {
+	if pCtx == nil {
+		return nil
+	}
+	tx, _ := pCtx.Value(txKey{}).(*Txn)
+	return tx
 }
 
 // Transaction is a wrapper of a *Txn for building Transactions.
@@ -32,6 +79,54 @@ type Transaction struct {
 	*Txn
 }
 
+// ******************************************** Transaction options
+
+// TxOption changes how a Transaction is run. The options of a
+// nested Transaction are those of the one it runs within.
+type TxOption func(*txOptions)
+
+type txOptions struct {
+	sql.TxOptions
+
+	// The most times the Action is run and the wait before
+	// the second which doubles after each further failure
+	attempts int
+	backoff  time.Duration
+}
+
+func newTxOptions(pOptions []TxOption) *txOptions {
+	o := new(txOptions)
+	for _, option := range pOptions {
+		option(o)
+	}
+	return o
+}
+
+// Isolation begins the Transaction at the isolation level
+func Isolation(pLevel sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.Isolation = pLevel
+	}
+}
+
+// ReadOnly begins a Transaction which cannot write
+func ReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// Retry runs the Action again in a new Transaction, up to the
+// number of attempts in all, when it fails with an error the
+// Dialect classifies as retryable. The wait between attempts
+// starts at the backoff and doubles after each.
+func Retry(pAttempts int, pBackoff time.Duration) TxOption {
+	return func(o *txOptions) {
+		o.attempts = pAttempts
+		o.backoff = pBackoff
+	}
+}
+
 // User simplified function types to build transactions
 type Action func(pTx Transaction) Result
 type TxArgs func(pTx Transaction, pArgs interface{}) error
@@ -55,37 +150,78 @@ type Select func(pTx Transaction) ([]Model, error)
 //		return t.Persist(person)
 //	})).Go()
 //
-func (o *Gem) Begin(fAction Action) *Txn {
+func (o *Gem) Begin(fAction Action, pOptions ...TxOption) *Txn {
+	return o.BeginContext(context.Background(), fAction, pOptions...)
+}
+
+// BeginContext makes a new Transaction as Begin does which starts
+// under the context and draws the values of scopes from it
+func (o *Gem) BeginContext(pCtx context.Context, fAction Action, pOptions ...TxOption) *Txn {
 	tx := new(Txn)
 	tx.funcAction = fAction
 	tx.gem = o
+	tx.ctx = pCtx
+	tx.options = newTxOptions(pOptions)
+	tx.parent = txFromContext(pCtx)
 	return tx
 }
 
+// GoContext runs the Transaction as Go does under the context.
+// Cancelling it rolls the Transaction back.
+func (o *Txn) GoContext(pCtx context.Context) (result Result, success bool) {
+	o.ctx = pCtx
+	return o.Go()
+}
+
 // Go runs the Transaction it has in from Gem.Begin Begin
 // Running Go will defer control of Rollback and
 // Commit functionality to the system.
 // If required a user can manually run a transaction using the
 // *sql.DB connection.
+// A panic in the Action rolls the transaction back and is passed on.
+// Begun under the Context of a Transaction of another Gem it fails
+// with ErrCrossShard.
 // Returns the result and whether the transaction was successful
 func (o *Txn) Go() (result Result, success bool) {
+	if o.parent != nil && o.parent.gem != o.gem {
+		return Result{Error: ErrCrossShard}, false
+	}
+	if o.parent != nil {
+		return o.goSavepoint()
+	}
+	for attempt := 1; ; attempt++ {
+		var cause error
+		result, success, cause = o.attempt()
+		result.Attempts = attempt
+		if success || attempt >= o.options.attempts || !isRetryable(o.gem.Dialect, cause) {
+			return result, success
+		}
+		select {
+		case <-time.After(o.options.backoff << uint(attempt-1)):
+		case <-o.context().Done():
+			return result, success
+		}
+	}
+}
+
+// Runs the Action once in a new Tx. The cause is the error which
+// failed the attempt before it was rolled back.
+func (o *Txn) attempt() (result Result, success bool, cause error) {
 	// Begin transaction
-	o.Tx, o.result.Error = o.gem.DB.Begin()
+	o.result = Result{}
+	o.Tx, o.result.Error = o.gem.DB.BeginTx(o.context(), &o.options.TxOptions)
 	if o.result.Error != nil {
-		return o.result, false
+		return o.result, false, o.result.Error
 	}
-	// Lock access to Gems protected variables
-	o.gem.txMu.Lock()
-	o.gem.tx = o
+	o.stmts = make(map[string]*sql.Stmt)
+	o.takeJournal()
 
 	// Do work
-	o.result = o.funcAction(Transaction{o})
-	for key, txStmt := range o.gem.txPreparedStatements {
-		txStmt.Close()
-		// delete(o.gem.txPreparedStatements, key)
-		// TODO Delete here
-		o.gem.txPreparedStatements[key] = nil
-	}
+	o.result = o.runAction(func() {
+		o.closeStmts()
+		o.Rollback()
+	})
+	o.closeStmts()
 
 	if o.result.Error != nil {
 		goto rollback
@@ -94,7 +230,14 @@ func (o *Txn) Go() (result Result, success bool) {
 	}
 rollback:
 	{
-		o.result.Error = o.Rollback()
+		// The cause is kept over an error rolling back
+		cause = o.result.Error
+		if err := o.Rollback(); cause == nil {
+			o.result.Error = err
+		}
+		o.restore()
+		_, afterRollback := o.callbacks()
+		runCallbacks(afterRollback)
 		result = o.result
 		success = false
 		goto done
@@ -106,26 +249,241 @@ commit:
 		if o.result.Error != nil {
 			goto rollback
 		}
+		o.takeJournal()
+		afterCommit, _ := o.callbacks()
+		runCallbacks(afterCommit)
 		result = o.result
 		success = true
 	}
 done:
-	// Clean transactional and unlock
-	o.gem.tx = nil
-	o.gem.txMu.Unlock()
-	return result, success
+	return result, success, cause
+}
+
+// Runs a nested Transaction within its parent under a savepoint.
+// An error rolls back only the work done since the savepoint and
+// is returned to the parent to handle.
+func (o *Txn) goSavepoint() (result Result, success bool) {
+	root := o.root()
+	o.Tx = root.Tx
+	name := fmt.Sprintf("opal_%d", atomic.AddInt32(&root.savepoints, 1))
+	dialect := o.gem.Dialect
+	if _, o.result.Error = o.ExecContext(o.context(), savepointSql(dialect, name)); o.result.Error != nil {
+		return o.result, false
+	}
+
+	// Do work
+	o.result = o.runAction(func() {
+		o.ExecContext(o.context(), rollbackToSql(dialect, name))
+	})
+	if o.result.Error != nil {
+		goto rollback
+	}
+	if release := releaseSql(dialect, name); release != "" {
+		if _, err := o.ExecContext(o.context(), release); err != nil {
+			o.result.Error = err
+			goto rollback
+		}
+	}
+	// The callbacks and journal wait on the outcome of the parent
+	{
+		o.parent.adopt(o.takeJournal())
+		afterCommit, afterRollback := o.callbacks()
+		for _, fAfter := range afterCommit {
+			o.parent.AfterCommit(fAfter)
+		}
+		for _, fAfter := range afterRollback {
+			o.parent.AfterRollback(fAfter)
+		}
+	}
+	o.result.Attempts = 1
+	return o.result, true
+rollback:
+	if _, err := o.ExecContext(o.context(), rollbackToSql(dialect, name)); err != nil && o.result.Error == nil {
+		o.result.Error = err
+	}
+	o.restore()
+	_, afterRollback := o.callbacks()
+	runCallbacks(afterRollback)
+	o.result.Attempts = 1
+	return o.result, false
+}
+
+// Runs the Action. Should it panic the work is rolled back with
+// the func and the rollback callbacks run before the panic is
+// passed on to the caller of Go.
+func (o *Txn) runAction(fRollback func()) Result {
+	defer func() {
+		if r := recover(); r != nil {
+			fRollback()
+			o.restore()
+			_, afterRollback := o.callbacks()
+			runCallbacks(afterRollback)
+			panic(r)
+		}
+	}()
+	return o.funcAction(Transaction{o})
+}
+
+// Closes the Gem's statements bound to the Tx
+func (o *Txn) closeStmts() {
+	o.stmtMu.Lock()
+	defer o.stmtMu.Unlock()
+	for _, txStmt := range o.stmts {
+		txStmt.Close()
+	}
+	o.stmts = nil
+}
+
+// AfterCommit registers a func to run once the Transaction has
+// committed. Those of a nested Transaction wait for the outermost.
+func (o *Txn) AfterCommit(fAfter func()) {
+	o.callbackMu.Lock()
+	defer o.callbackMu.Unlock()
+	o.afterCommit = append(o.afterCommit, fAfter)
+}
+
+// AfterRollback registers a func to run once the Transaction has
+// rolled back including each failed attempt of a retried one
+func (o *Txn) AfterRollback(fAfter func()) {
+	o.callbackMu.Lock()
+	defer o.callbackMu.Unlock()
+	o.afterRollback = append(o.afterRollback, fAfter)
+}
+
+// Takes the registered callbacks leaving none for the next attempt
+func (o *Txn) callbacks() (afterCommit, afterRollback []func()) {
+	o.callbackMu.Lock()
+	defer o.callbackMu.Unlock()
+	afterCommit, afterRollback = o.afterCommit, o.afterRollback
+	o.afterCommit, o.afterRollback = nil, nil
+	return
+}
+
+// Runs callbacks in the order they were registered
+func runCallbacks(fCallbacks []func()) {
+	for _, fCallback := range fCallbacks {
+		fCallback()
+	}
+}
+
+// ******************************************** Model journal
+
+// The values and snapshot of a Model before its first write in
+// a Txn
+type modelState struct {
+	model    Model
+	values   []driver.Value
+	snapshot []driver.Value
+}
+
+// The snapshot of an Entity which a rollback can put back
+type snapshotter interface {
+	snapshotValues() []driver.Value
+	restoreSnapshot([]driver.Value)
+}
+
+// Records the state of a Model about to be written under the
+// Transaction of the Execor if there is one. Its snapshot and
+// the fields opal sets, such as the version, are put back should
+// the Transaction roll back so a retry writes the Model again.
+func journalModel(pExecor Execor, pModel Model) {
+	if tx := txFromContext(pExecor.ExecorContext()); tx != nil {
+		tx.record(pModel)
+	}
+}
+
+// Records the state of the Model unless it was already. Models
+// are told apart by their first key which each holds apart.
+func (o *Txn) record(pModel Model) {
+	key := BindArgs(pModel)[0]
+	o.journalMu.Lock()
+	defer o.journalMu.Unlock()
+	if o.journaled[key] {
+		return
+	}
+	if o.journaled == nil {
+		o.journaled = make(map[interface{}]bool)
+	}
+	o.journaled[key] = true
+	state := modelState{model: pModel, values: modelValues(pModel)}
+	if entity, ok := snapshotterOf(pModel); ok {
+		state.snapshot = entity.snapshotValues()
+	}
+	o.journal = append(o.journal, state)
+}
+
+// Gets the snapshotter of a Model's Entity if it has one
+func snapshotterOf(pModel Model) (snapshotter, bool) {
+	field := entityField(pModel)
+	if !field.IsValid() || field.IsNil() {
+		return nil, false
+	}
+	entity, ok := field.Interface().(snapshotter)
+	return entity, ok
+}
+
+// Takes the journal leaving it empty
+func (o *Txn) takeJournal() []modelState {
+	o.journalMu.Lock()
+	defer o.journalMu.Unlock()
+	journal := o.journal
+	o.journal, o.journaled = nil, nil
+	return journal
+}
+
+// Takes on the journal of a released nested Txn keeping the
+// earlier state of the Models already recorded
+func (o *Txn) adopt(pJournal []modelState) {
+	for _, state := range pJournal {
+		key := BindArgs(state.model)[0]
+		o.journalMu.Lock()
+		if !o.journaled[key] {
+			if o.journaled == nil {
+				o.journaled = make(map[interface{}]bool)
+			}
+			o.journaled[key] = true
+			o.journal = append(o.journal, state)
+		}
+		o.journalMu.Unlock()
+	}
+}
+
+// Puts back the state of the Models written, the latest first
+func (o *Txn) restore() {
+	journal := o.takeJournal()
+	for i := len(journal) - 1; i >= 0; i-- {
+		state := journal[i]
+		restoreValues(state.model, state.values)
+		if entity, ok := snapshotterOf(state.model); ok {
+			entity.restoreSnapshot(state.snapshot)
+		}
+	}
+}
+
+// Gets the outermost Txn which holds the Tx
+func (o *Txn) root() *Txn {
+	for o.parent != nil {
+		o = o.parent
+	}
+	return o
+}
+
+// Begin makes a Transaction nested within this one which
+// runs under a savepoint when Go is called
+func (o *Txn) Begin(fAction Action) *Txn {
+	return o.gem.BeginContext(o.Context(), fAction)
 }
 
 // Update will update the Model within a Transaction.
 // Call this within a Txn func Action
 func (o *Txn) Update(pModel Model) Result {
-	return merge(o, pModel)
+	return o.gem.saveModel(o, pModel, o.gem.merge)
 }
 
 // Persist will insert the Model within a Transaction.
 // Call this within a Txn func Action
 func (o *Txn) Insert(pModel Model) Result {
-	return persist(o, pModel)
+	return o.gem.saveModel(o, pModel, insertModel)
 }
 
 // Persist will insert the Model within a Transaction.
@@ -140,28 +498,73 @@ func (o *Txn) Create(pModel Model, pArgs Args) Result {
 // Delete will delete the Model within a Transaction.
 // Call this within a Txn func Action
 func (o *Txn) Delete(pModel Model) Result {
-	return remove(o, pModel)
+	return o.gem.deleteModel(o, pModel, remove, withoutDeleted)
+}
+
+// HardDelete will delete the Model within a Transaction even
+// when it is soft deleted. Call this within a Txn func Action
+func (o *Txn) HardDelete(pModel Model) Result {
+	return o.gem.deleteModel(o, pModel, hardRemove, withDeleted)
 }
 
 func (o *Txn) Exec(pSql Sql, pArgs ...interface{}) Result {
-	result, err := o.Tx.Exec(pSql.String(), pArgs...)
-	return Result{result, err}
+	result, err := o.Tx.ExecContext(o.context(), pSql.String(), pArgs...)
+	return Result{Result: result, Error: err}
 }
 
 type StmtExec func(...interface{}) (*sql.Result, error)
 type StmtQuery func(...interface{}) (*sql.Rows, error)
 type StmtQueryRow func(...interface{}) *sql.Row
 
-func (o *Txn) ExecorStmt(pModelName ModelName, pNamedStmt string) *sql.Stmt {
-	stmt := o.gem.allModelsMetadata[pModelName].preparedStatements[pNamedStmt]
-	return o.stmt(stmt)
+// Gets the context the Transaction began under
+func (o *Txn) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// Context gets a context bound to the Transaction. A DAO given
+// it through WithContext runs its calls inside the Transaction.
+func (o *Txn) Context() context.Context {
+	return context.WithValue(o.context(), txKey{}, o)
 }
 
-func (o *Txn) stmt(pStmt *sql.Stmt) *sql.Stmt {
-	if v, ok := o.gem.txPreparedStatements[pStmt]; ok && v != nil { // TODO remove nil once delete works
-		return v
+// Gets the context the Transaction's calls run under
+func (o *Txn) ExecorContext() context.Context {
+	return o.Context()
+}
+
+// Runs the call with the named statement prepared on the Tx. A
+// statement which fails is not run again as the failure may have
+// aborted the Tx.
+func (o *Txn) ExecorStmt(pModelName ModelName, pNamedStmt string, fRun func(*sql.Stmt) error) error {
+	stmt, err := o.stmt(pModelName, pNamedStmt)
+	if err != nil {
+		return err
+	}
+	return fRun(stmt)
+}
+
+// Gets the named statement of the Model prepared on the Tx
+func (o *Txn) stmt(pModelName ModelName, pNamedStmt string) (*sql.Stmt, error) {
+	if o.parent != nil {
+		return o.root().stmt(pModelName, pNamedStmt)
+	}
+	o.stmtMu.Lock()
+	defer o.stmtMu.Unlock()
+	key := pModelName.String() + "." + pNamedStmt
+	if v, ok := o.stmts[key]; ok {
+		return v, nil
+	}
+	query, _, err := o.gem.stmtSql(pModelName, pNamedStmt)
+	if err != nil {
+		return nil, err
+	}
+	txStmt, err := o.PrepareContext(o.context(), query)
+	if err != nil {
+		return nil, err
 	}
-	txStmt := o.Stmt(pStmt)
-	o.gem.txPreparedStatements[pStmt] = txStmt
-	return txStmt
+	o.stmts[key] = txStmt
+	return txStmt, nil
 }
@@ -0,0 +1,457 @@
+package opal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransactionIsolation(t *testing.T) {
+	gem, _ := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	begun, written := make(chan struct{}), make(chan struct{})
+	done := make(chan Result)
+	go func() {
+		result, _ := gem.Begin(func(pTx Transaction) Result {
+			o := rawWide()
+			o.C0 = NewString("in transaction")
+			if result := pTx.Insert(o); result.Error != nil {
+				return result
+			}
+			close(begun)
+			<-written
+			return Result{Error: errors.New("rollback")}
+		}).Go()
+		done <- result
+	}()
+
+	// A write outside the open transaction is not drawn into it
+	<-begun
+	o := rawWide()
+	o.C0 = NewString("outside")
+	if result := wides.Insert(o); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	close(written)
+	<-done
+
+	models := wides.FindAllModels()
+	if len(models) != 1 || models[0].(*wide).C0.String() != "outside" {
+		t.Errorf("FindAllModels() = %v, want only the write outside the transaction", models)
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	gem, _ := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			gem.Begin(func(pTx Transaction) Result {
+				inTx := wides.WithContext(pTx.Context())
+				for j := 0; j < 3; j++ {
+					o := rawWide()
+					o.C1 = NewInt64(int64(i))
+					if result := inTx.Insert(o); result.Error != nil {
+						return result
+					}
+				}
+				if i%2 == 1 {
+					return Result{Error: errors.New("rollback")}
+				}
+				return Result{}
+			}).Go()
+		}(i)
+		go func() {
+			defer wg.Done()
+			wides.Insert(rawWide())
+		}()
+	}
+	wg.Wait()
+
+	// Each committed transaction wrote three and each plain insert one
+	if got, want := len(wides.FindAllModels()), n/2*3+n; got != want {
+		t.Errorf("FindAllModels() found %d, want %d", got, want)
+	}
+}
+
+// savepointDialect names savepoints its own way and has no release
+type savepointDialect struct {
+	testDialect
+}
+
+func (savepointDialect) Savepoint(pName string) string {
+	return "SAVEPOINT sp_" + pName
+}
+
+func (savepointDialect) RollbackToSavepoint(pName string) string {
+	return "ROLLBACK TO SAVEPOINT sp_" + pName
+}
+
+func (savepointDialect) ReleaseSavepoint(pName string) string {
+	return ""
+}
+
+func TestNestedTransactions(t *testing.T) {
+	for _, dialect := range []Dialect{testDialect{}, savepointDialect{}} {
+		gem, db := testDialectGem(t, dialect, new(wide))
+		wides := &ModelIDAO{gem: gem, model: wideModel}
+		insert := func(pDAO *ModelIDAO, pName string) Result {
+			o := rawWide()
+			o.C0 = NewString(pName)
+			return pDAO.Insert(o)
+		}
+
+		db.reset()
+		_, ok := gem.Begin(func(pTx Transaction) Result {
+			insert(wides.WithContext(pTx.Context()), "outer")
+			// A failed nested Transaction only rolls back its own work
+			if _, ok := pTx.Begin(func(pInner Transaction) Result {
+				insert(wides.WithContext(pInner.Context()), "failed")
+				return Result{Error: errors.New("rollback")}
+			}).Go(); ok {
+				t.Error("Go() of the failed nested transaction succeeded")
+			}
+			// A service given the context joins the Transaction
+			result, _ := gem.BeginContext(pTx.Context(), func(pInner Transaction) Result {
+				return insert(wides.WithContext(pInner.Context()), "nested")
+			}).Go()
+			return result
+		}).Go()
+		if !ok {
+			t.Fatalf("%T: Go() of the outer transaction failed", dialect)
+		}
+
+		var names []string
+		for _, model := range wides.FindAllModels() {
+			names = append(names, model.(*wide).C0.String())
+		}
+		if !reflect.DeepEqual(names, []string{"outer", "nested"}) {
+			t.Errorf("%T: FindAllModels() = %v", dialect, names)
+		}
+		want := []string{"SAVEPOINT opal_1", "ROLLBACK TO SAVEPOINT opal_1", "SAVEPOINT opal_2", "RELEASE SAVEPOINT opal_2"}
+		if _, ok := dialect.(savepointDialect); ok {
+			want = []string{"SAVEPOINT sp_opal_1", "ROLLBACK TO SAVEPOINT sp_opal_1", "SAVEPOINT sp_opal_2"}
+		}
+		if got := savepoints(db.executed); !reflect.DeepEqual(got, want) || db.count("BEGIN") != 1 {
+			t.Errorf("%T: executed %q, want one BEGIN and savepoints %q", dialect, db.executed, want)
+		}
+	}
+}
+
+// Gets the savepoint statements which were executed
+func savepoints(pExecuted []string) (statements []string) {
+	for _, statement := range pExecuted {
+		if strings.Contains(statement, "SAVEPOINT") {
+			statements = append(statements, statement)
+		}
+	}
+	return
+}
+
+// busyDialect retries the errors of a busy database
+type busyDialect struct {
+	testDialect
+}
+
+var errBusy = errors.New("busy")
+
+func (busyDialect) IsRetryable(pErr error) bool {
+	return pErr == errBusy
+}
+
+func TestTransactionOptions(t *testing.T) {
+	gem, db := testDialectGem(t, busyDialect{}, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	db.reset()
+	gem.Begin(func(pTx Transaction) Result {
+		return Result{}
+	}, Isolation(sql.LevelSerializable), ReadOnly()).Go()
+	if want := []string{"BEGIN Serializable READ ONLY", "COMMIT"}; !reflect.DeepEqual(db.executed, want) {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+
+	// The Action is run again until it stops failing with a retryable error
+	runs := 0
+	db.reset()
+	result, ok := gem.Begin(func(pTx Transaction) Result {
+		runs++
+		if result := wides.WithContext(pTx.Context()).Insert(rawWide()); result.Error != nil {
+			return result
+		}
+		if runs < 3 {
+			return Result{Error: errBusy}
+		}
+		return Result{}
+	}, Retry(5, time.Millisecond)).Go()
+	if !ok || result.Attempts != 3 || runs != 3 {
+		t.Errorf("Go() = %v, %v after %d runs, want success on the third attempt", result, ok, runs)
+	}
+	if db.count("BEGIN") != 3 || db.count("ROLLBACK") != 2 || db.count("COMMIT") != 1 {
+		t.Errorf("executed %q, want three attempts", db.executed)
+	}
+	if models := wides.FindAllModels(); len(models) != 1 {
+		t.Errorf("FindAllModels() found %d, want only the last attempt's insert", len(models))
+	}
+
+	// Attempts are bounded and other errors are not retried
+	for _, test := range []struct {
+		err      error
+		attempts int
+	}{{errBusy, 2}, {errors.New("other"), 1}} {
+		result, ok := gem.Begin(func(pTx Transaction) Result {
+			return Result{Error: test.err}
+		}, Retry(2, time.Millisecond)).Go()
+		if ok || result.Attempts != test.attempts || result.Error != test.err {
+			t.Errorf("%v: Go() = %v after %d attempts, want failure after %d", test.err, ok, result.Attempts, test.attempts)
+		}
+	}
+}
+
+func TestTransactionError(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	// The error of the Action is returned once rolled back
+	failed := errors.New("failed")
+	fAction := func(pTx Transaction) Result {
+		wides.WithContext(pTx.Context()).Insert(rawWide())
+		return Result{Error: failed}
+	}
+	if result, _ := gem.Begin(fAction).Go(); result.Error != failed {
+		t.Errorf("Go() error = %v, want the error of the Action", result.Error)
+	}
+	gem.Begin(func(pTx Transaction) Result {
+		if result, _ := pTx.Begin(fAction).Go(); result.Error != failed {
+			t.Errorf("nested Go() error = %v, want the error of the Action", result.Error)
+		}
+		return Result{}
+	}).Go()
+
+	// So is the error of a failed commit
+	committed := errors.New("commit failed")
+	db.mu.Lock()
+	db.failCommit = committed
+	db.mu.Unlock()
+	result, ok := gem.Begin(func(pTx Transaction) Result {
+		return wides.WithContext(pTx.Context()).Insert(rawWide())
+	}).Go()
+	if ok || result.Error != committed {
+		t.Errorf("Go() = %v, %v, want the commit error", result, ok)
+	}
+	if models := wides.FindAllModels(); len(models) != 0 {
+		t.Errorf("FindAllModels() found %d, want the failed commit rolled back", len(models))
+	}
+}
+
+func TestRollbackRestoresModels(t *testing.T) {
+	gem, db := testDialectGem(t, busyDialect{}, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+	o := rawWide()
+	wides.Insert(o)
+
+	// A retry writes the changes the rolled back attempt made
+	runs := 0
+	o.C0 = NewString("retried")
+	db.reset()
+	_, ok := gem.Begin(func(pTx Transaction) Result {
+		runs++
+		if result := wides.WithContext(pTx.Context()).Save(o); result.Error != nil {
+			return result
+		}
+		if runs < 2 {
+			return Result{Error: errBusy}
+		}
+		return Result{}
+	}, Retry(2, time.Millisecond)).Go()
+	if !ok || db.count("UPDATE") != 2 {
+		t.Errorf("Go() = %v executing %q, want the update made by each attempt", ok, db.executed)
+	}
+	if found := wides.FindModel(o.Id.Primitive()).(*wide); found.C0.String() != "retried" {
+		t.Errorf("found C0 %q, want the retried update", found.C0.String())
+	}
+
+	// As does a write after a rollback
+	o.C0 = NewString("rolled back")
+	inserted := rawWide()
+	gem.Begin(func(pTx Transaction) Result {
+		wides.WithContext(pTx.Context()).Save(o)
+		wides.WithContext(pTx.Context()).Insert(inserted)
+		return Result{Error: errors.New("rollback")}
+	}).Go()
+	if !o.IsDirty() || inserted.Id.Primitive() != 0 {
+		t.Errorf("dirty %v, inserted Id %d, want the Models as before", o.IsDirty(), inserted.Id.Primitive())
+	}
+	wides.Save(o)
+	if found := wides.FindModel(o.Id.Primitive()).(*wide); found.C0.String() != "rolled back" {
+		t.Errorf("found C0 %q, want the update made after the rollback", found.C0.String())
+	}
+}
+
+// sqlStateError carries a Sql state as Postgres drivers do
+type sqlStateError string
+
+func (o sqlStateError) Error() string {
+	return "sql state " + string(o)
+}
+
+func (o sqlStateError) SQLState() string {
+	return string(o)
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("no such table"), false},
+		{errors.New("database is locked"), true},
+		{errors.New("SQLITE_BUSY: cannot commit"), true},
+		{sqlStateError("40001"), true},
+		{fmt.Errorf("commit: %w", sqlStateError("40P01")), true},
+		{sqlStateError("23505"), false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(testDialect{}, test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+	if isRetryable(busyDialect{}, errors.New("database is locked")) {
+		t.Error("isRetryable() ignored the RetryDialect")
+	}
+}
+
+// committedWide counts the commits of its inserts
+type committedWide struct {
+	*wide
+	commits *int
+}
+
+func (o committedWide) AfterCommitInsertHook() {
+	*o.commits++
+}
+
+func TestAfterCommit(t *testing.T) {
+	gem, _ := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	var events []string
+	event := func(pEvent string) func() {
+		return func() { events = append(events, pEvent) }
+	}
+	commits := 0
+	for _, fail := range []bool{true, false} {
+		events, commits = nil, 0
+		gem.Begin(func(pTx Transaction) Result {
+			pTx.AfterCommit(event("commit"))
+			pTx.AfterRollback(event("rollback"))
+			wides.WithContext(pTx.Context()).Insert(committedWide{gem.Bind(rawWide()).(*wide), &commits})
+			// A rolled back nested Transaction runs its own callbacks
+			pTx.Begin(func(pInner Transaction) Result {
+				pInner.AfterCommit(event("inner commit"))
+				pInner.AfterRollback(event("inner rollback"))
+				return Result{Error: errors.New("rollback")}
+			}).Go()
+			// A released one waits for the outcome of its parent
+			pTx.Begin(func(pInner Transaction) Result {
+				pInner.AfterCommit(event("released commit"))
+				pInner.AfterRollback(event("released rollback"))
+				return Result{}
+			}).Go()
+			if commits != 0 {
+				t.Errorf("AfterCommitInsertHook() ran %d times before the commit", commits)
+			}
+			if fail {
+				return Result{Error: errors.New("rollback")}
+			}
+			return Result{}
+		}).Go()
+
+		want := []string{"inner rollback", "rollback", "released rollback"}
+		wantCommits := 0
+		if !fail {
+			want = []string{"inner rollback", "commit", "released commit"}
+			wantCommits = 1
+		}
+		if !reflect.DeepEqual(events, want) || commits != wantCommits {
+			t.Errorf("fail %v: ran %q and %d commit hooks, want %q and %d", fail, events, commits, want, wantCommits)
+		}
+	}
+
+	// Without a transaction the write is committed when it is made
+	commits = 0
+	wides.Insert(committedWide{gem.Bind(rawWide()).(*wide), &commits})
+	if commits != 1 {
+		t.Errorf("AfterCommitInsertHook() ran %d times outside a transaction", commits)
+	}
+}
+
+func TestTransactionPanic(t *testing.T) {
+	gem, db := testGem(t, new(wide))
+	wides := &ModelIDAO{gem: gem, model: wideModel}
+
+	for _, nested := range []bool{false, true} {
+		rolledBack := 0
+		recovered := func() (r interface{}) {
+			defer func() { r = recover() }()
+			gem.Begin(func(pTx Transaction) Result {
+				pTx.AfterRollback(func() { rolledBack++ })
+				action := func(pTx Transaction) Result {
+					pTx.AfterRollback(func() { rolledBack++ })
+					wides.WithContext(pTx.Context()).Insert(rawWide())
+					panic("boom")
+				}
+				if nested {
+					result, _ := pTx.Begin(action).Go()
+					return result
+				}
+				return action(pTx)
+			}).Go()
+			return nil
+		}()
+		if recovered != "boom" {
+			t.Errorf("nested %v: recovered %v, want the panic passed on", nested, recovered)
+		}
+		if rolledBack != 2 {
+			t.Errorf("nested %v: %d rollback callbacks ran, want 2", nested, rolledBack)
+		}
+		if models := wides.FindAllModels(); len(models) != 0 {
+			t.Errorf("nested %v: FindAllModels() = %v after the panic", nested, models)
+		}
+	}
+
+	// A panic under a savepoint rolls back to it and then the whole Tx
+	db.reset()
+	func() {
+		defer func() { recover() }()
+		gem.Begin(func(pTx Transaction) Result {
+			pTx.Begin(func(pInner Transaction) Result {
+				panic("boom")
+			}).Go()
+			return Result{}
+		}).Go()
+	}()
+	want := []string{"BEGIN", "SAVEPOINT opal_1", "ROLLBACK TO SAVEPOINT opal_1", "ROLLBACK"}
+	if !reflect.DeepEqual(db.executed, want) {
+		t.Errorf("executed %q, want %q", db.executed, want)
+	}
+
+	// The connection and its statements were released
+	if _, ok := gem.Begin(func(pTx Transaction) Result {
+		return wides.WithContext(pTx.Context()).Insert(rawWide())
+	}).Go(); !ok {
+		t.Error("Go() failed after a panic")
+	}
+}
@@ -304,6 +304,17 @@ func NewAutoIncrement(p int64) *AutoIncrement {
 	return &AutoIncrement{NewInt64(p)}
 }
 
+// Is an Int64 under the covers which versions a Model's rows
+// so concurrent updates cannot overwrite each other
+type Version struct {
+	Int64
+}
+
+// Makes a new Version
+func NewVersion(p int64) *Version {
+	return &Version{NewInt64(p)}
+}
+
 // ************************************************  FLOAT64 TYPE
 
 // Float64 represents an int64 that may be null.